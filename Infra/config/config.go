@@ -2,6 +2,7 @@ package config
 
 import (
 	"path/filepath"
+	"time"
 
 	"github.com/linchengzhi/lottery/domain/dto"
 	"github.com/mitchellh/mapstructure"
@@ -9,6 +10,42 @@ import (
 )
 
 func NewConfig(path string) (*dto.Config, error) {
+	v, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &dto.Config{}
+	err = v.Unmarshal(c, decoderOption)
+	if err != nil {
+		return nil, err
+	}
+	c.Path = path
+	return c, nil
+}
+
+// NewLotteryConf 读取活动配置，文件可以是完整的服务配置(读取 lottery 列表)，也可以是单个活动配置
+func NewLotteryConf(path string) ([]dto.LotteryConf, error) {
+	v, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.IsSet("lottery") {
+		var list []dto.LotteryConf
+		if err = v.UnmarshalKey("lottery", &list, decoderOption); err != nil {
+			return nil, err
+		}
+		return list, nil
+	}
+	var conf dto.LotteryConf
+	if err = v.Unmarshal(&conf, decoderOption); err != nil {
+		return nil, err
+	}
+	return []dto.LotteryConf{conf}, nil
+}
+
+func readConfig(path string) (*viper.Viper, error) {
 	v := viper.New()
 
 	dir, file := filepath.Split(path)
@@ -18,18 +55,17 @@ func NewConfig(path string) (*dto.Config, error) {
 	v.AddConfigPath(dir)      // 添加配置文件所在的目录
 	v.SetConfigType("yaml")   // 设置配置文件的类型
 
-	err := v.ReadInConfig()
-	if err != nil {
-		return nil, err
-	}
-
-	c := &dto.Config{}
-	op := viper.DecoderConfigOption(func(c *mapstructure.DecoderConfig) {
-		c.TagName = "yaml"
-	})
-	err = v.Unmarshal(c, op)
-	if err != nil {
+	if err := v.ReadInConfig(); err != nil {
 		return nil, err
 	}
-	return c, nil
+	return v, nil
 }
+
+var decoderOption = viper.DecoderConfigOption(func(c *mapstructure.DecoderConfig) {
+	c.TagName = "yaml"
+	c.DecodeHook = mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+		mapstructure.StringToTimeHookFunc(time.RFC3339), // 时间格式 2006-01-02T15:04:05+08:00
+	)
+})
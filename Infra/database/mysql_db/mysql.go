@@ -130,5 +130,8 @@ func AutoMigrate(db *gorm.DB) error {
 		userItemRecord := entity.UserItemRecord{UserID: i}
 		db.Table(userItemRecord.TableName()).AutoMigrate(userItemRecord)
 	}
+	db.AutoMigrate(entity.LotteryPoolVersion{})
+	db.AutoMigrate(entity.LotterySeed{})
+	db.AutoMigrate(entity.UserProfile{})
 	return nil
 }
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/linchengzhi/lottery/Infra/config"
+	"github.com/linchengzhi/lottery/domain/cerror"
+	"github.com/linchengzhi/lottery/domain/dto"
+	"github.com/linchengzhi/lottery/usecase/lottery_uc"
+	"go.uber.org/zap"
+)
+
+type AdminHdr struct {
+	lotteryUc lottery_uc.LotteryUc
+	confPath  string
+	log       *zap.Logger
+}
+
+func NewAdminHandler(uc lottery_uc.LotteryUc, confPath string, log *zap.Logger) *AdminHdr {
+	return &AdminHdr{
+		uc,
+		confPath,
+		log,
+	}
+}
+
+// ReloadLottery 重新读取配置文件并热加载奖池
+func (hdr *AdminHdr) ReloadLottery(c *gin.Context) (interface{}, error) {
+	conf, err := config.NewConfig(hdr.confPath)
+	if err != nil {
+		hdr.log.Error("热加载奖池 读取配置失败", zap.String("path", hdr.confPath), zap.Error(err))
+		return nil, cerror.ErrLotteryConfig
+	}
+	hdr.log.Info("热加载奖池", zap.String("path", hdr.confPath))
+	resp := hdr.lotteryUc.ReloadPrizePool(c.Request.Context(), conf.Lottery)
+	hdr.log.Info("热加载奖池完成", zap.Any("resp", resp))
+	return resp, nil
+}
+
+// RotateSeed 开启活动新的种子周期，并公开上一个周期的种子
+func (hdr *AdminHdr) RotateSeed(c *gin.Context) (interface{}, error) {
+	req := new(dto.SeedReq)
+	if err := c.ShouldBindJSON(req); err != nil || req.ActivityId == 0 {
+		hdr.log.Error("参数错误", zap.Any("req", req), zap.Error(err))
+		return nil, cerror.ErrParam
+	}
+	resp, err := hdr.lotteryUc.RotateSeed(c.Request.Context(), req.ActivityId)
+	if err != nil {
+		hdr.log.Error("更换种子失败", zap.Any("req", req), zap.Error(err))
+		return nil, err
+	}
+	hdr.log.Info("更换种子", zap.Any("resp", resp))
+	return resp, nil
+}
+
+// ExperimentReport 获取活动A/B实验各分组的统计
+func (hdr *AdminHdr) ExperimentReport(c *gin.Context) (interface{}, error) {
+	req := new(dto.ExperimentReq)
+	if err := c.ShouldBindQuery(req); err != nil || req.ActivityId == 0 {
+		hdr.log.Error("参数错误", zap.Any("req", req), zap.Error(err))
+		return nil, cerror.ErrParam
+	}
+	resp, err := hdr.lotteryUc.ExperimentReport(c.Request.Context(), req.ActivityId)
+	if err != nil {
+		hdr.log.Error("获取实验报告失败", zap.Any("req", req), zap.Error(err))
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ReplayDraw 使用抽奖记录中保存的种子重现抽奖
+func (hdr *AdminHdr) ReplayDraw(c *gin.Context) (interface{}, error) {
+	req := new(dto.VerifyReq)
+	if err := c.ShouldBindQuery(req); err != nil || req.ActivityId == 0 || req.RequestId == "" {
+		hdr.log.Error("参数错误", zap.Any("req", req), zap.Error(err))
+		return nil, cerror.ErrParam
+	}
+	resp, err := hdr.lotteryUc.Replay(c.Request.Context(), req.ActivityId, req.RequestId)
+	if err != nil {
+		hdr.log.Error("重现抽奖失败", zap.Any("req", req), zap.Error(err))
+		return nil, err
+	}
+	hdr.log.Info("重现抽奖", zap.Any("req", req), zap.Bool("match", resp.Match))
+	return resp, nil
+}
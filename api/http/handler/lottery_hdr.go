@@ -6,9 +6,11 @@ import (
 	"github.com/linchengzhi/lottery/api/http/middleware"
 	"github.com/linchengzhi/lottery/domain/cerror"
 	"github.com/linchengzhi/lottery/domain/dto"
+	"github.com/linchengzhi/lottery/domain/types"
 	"github.com/linchengzhi/lottery/usecase/lottery_uc"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
+	"strconv"
 	"time"
 )
 
@@ -36,6 +38,7 @@ func (hdr *LotteryHdr) DrawLottery(c *gin.Context) (interface{}, error) {
 	}
 	req.RequestId = c.GetHeader("request_id")
 	req.RequestTime = time.Now()
+	req.Attr = userAttrFromHeader(c)
 	hdr.log.Info("抽奖", zap.Any("req", req))
 
 	// 设置30s超时
@@ -73,6 +76,136 @@ func (hdr *LotteryHdr) ListPrize(c *gin.Context) (interface{}, error) {
 	return resp, nil
 }
 
+// ListSeed 获取活动的服务端种子周期，未公开的种子只返回摘要
+func (hdr *LotteryHdr) ListSeed(c *gin.Context) (interface{}, error) {
+	req := new(dto.SeedReq)
+	if err := c.ShouldBindQuery(req); err != nil || req.ActivityId == 0 {
+		hdr.log.Error("参数错误", zap.Any("req", req), zap.Error(err))
+		return nil, cerror.ErrParam
+	}
+	resp, err := hdr.lotteryUc.ListSeeds(c.Request.Context(), req.ActivityId)
+	if err != nil {
+		hdr.log.Error("获取种子列表失败", zap.Any("req", req), zap.Error(err))
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Verify 根据已公开的种子重新计算抽奖结果
+func (hdr *LotteryHdr) Verify(c *gin.Context) (interface{}, error) {
+	req := new(dto.VerifyReq)
+	if err := c.ShouldBindQuery(req); err != nil || req.ActivityId == 0 || req.RequestId == "" {
+		hdr.log.Error("参数错误", zap.Any("req", req), zap.Error(err))
+		return nil, cerror.ErrParam
+	}
+	hdr.log.Info("验证抽奖", zap.Any("req", req))
+	resp, err := hdr.lotteryUc.Verify(c.Request.Context(), req.ActivityId, req.RequestId)
+	if err != nil {
+		hdr.log.Error("验证抽奖失败", zap.Any("req", req), zap.Error(err))
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Odds 获取活动的概率公示
+func (hdr *LotteryHdr) Odds(c *gin.Context) (interface{}, error) {
+	req := new(dto.OddsReq)
+	if err := c.ShouldBindQuery(req); err != nil || req.ActivityId == 0 {
+		hdr.log.Error("参数错误", zap.Any("req", req), zap.Error(err))
+		return nil, cerror.ErrParam
+	}
+	resp, err := hdr.lotteryUc.Odds(c.Request.Context(), req)
+	if err != nil {
+		hdr.log.Error("获取概率公示失败", zap.Any("req", req), zap.Error(err))
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Box 获取箱子模式中用户箱子剩余的奖品
+func (hdr *LotteryHdr) Box(c *gin.Context) (interface{}, error) {
+	req := new(dto.BoxReq)
+	if err := c.ShouldBindQuery(req); err != nil || req.ActivityId == 0 || req.UserId == 0 {
+		hdr.log.Error("参数错误", zap.Any("req", req), zap.Error(err))
+		return nil, cerror.ErrParam
+	}
+	resp, err := hdr.lotteryUc.Box(c.Request.Context(), req)
+	if err != nil {
+		hdr.log.Error("获取箱子失败", zap.Any("req", req), zap.Error(err))
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ResetBox 抽出大奖后重置用户的箱子
+func (hdr *LotteryHdr) ResetBox(c *gin.Context) (interface{}, error) {
+	req := new(dto.BoxReq)
+	if err := c.ShouldBindJSON(req); err != nil || req.ActivityId == 0 || req.UserId == 0 {
+		hdr.log.Error("参数错误", zap.Any("req", req), zap.Error(err))
+		return nil, cerror.ErrParam
+	}
+	hdr.log.Info("重置箱子", zap.Any("req", req))
+	resp, err := hdr.lotteryUc.ResetBox(c.Request.Context(), req)
+	if err != nil {
+		hdr.log.Error("重置箱子失败", zap.Any("req", req), zap.Error(err))
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Spark 获取用户的兑换积分和可兑换的奖品
+func (hdr *LotteryHdr) Spark(c *gin.Context) (interface{}, error) {
+	req := new(dto.SparkReq)
+	if err := c.ShouldBindQuery(req); err != nil || req.ActivityId == 0 || req.UserId == 0 {
+		hdr.log.Error("参数错误", zap.Any("req", req), zap.Error(err))
+		return nil, cerror.ErrParam
+	}
+	resp, err := hdr.lotteryUc.Spark(c.Request.Context(), req)
+	if err != nil {
+		hdr.log.Error("获取兑换积分失败", zap.Any("req", req), zap.Error(err))
+		return nil, err
+	}
+	return resp, nil
+}
+
+// RedeemSpark 使用兑换积分兑换奖品，request_id 用于幂等，重试时必须相同
+func (hdr *LotteryHdr) RedeemSpark(c *gin.Context) (interface{}, error) {
+	req := new(dto.RedeemReq)
+	if err := c.ShouldBindJSON(req); err != nil || req.ActivityId == 0 || req.UserId == 0 || req.PrizeId == 0 {
+		hdr.log.Error("参数错误", zap.Any("req", req), zap.Error(err))
+		return nil, cerror.ErrParam
+	}
+	req.RequestId = c.GetHeader("request_id")
+	if req.RequestId == "" || len(req.RequestId) > 36 {
+		hdr.log.Error("参数错误 request_id 为空或过长", zap.Any("req", req))
+		return nil, cerror.ErrParam
+	}
+	req.RequestTime = time.Now()
+	hdr.log.Info("兑换奖品", zap.Any("req", req))
+	resp, err := hdr.lotteryUc.RedeemSpark(c.Request.Context(), req)
+	if err != nil {
+		hdr.log.Error("兑换奖品失败", zap.Any("req", req), zap.Error(err))
+		return nil, err
+	}
+	return resp, nil
+}
+
+// 读取网关传入的用户属性，user_register_time 为注册时间的unix秒数，都未传入时返回 nil
+// 仅在 user_attr.provider 为 header 时使用，网关必须覆盖客户端传入的同名请求头
+func userAttrFromHeader(c *gin.Context) *dto.UserAttr {
+	registerTime := c.GetHeader("user_register_time")
+	vip := c.GetHeader("user_vip")
+	if registerTime == "" && vip == "" {
+		return nil
+	}
+	attr := new(dto.UserAttr)
+	if sec, err := strconv.ParseInt(registerTime, 10, 64); err == nil && sec > 0 {
+		attr.RegisterTime = time.Unix(sec, 0)
+	}
+	attr.Vip, _ = strconv.ParseInt(vip, 10, 64)
+	return attr
+}
+
 // 参数校验函数
 func (hdr *LotteryHdr) validateDrawRequest(req *dto.DrawReq) error {
 	if req.UserId == 0 {
@@ -81,6 +214,18 @@ func (hdr *LotteryHdr) validateDrawRequest(req *dto.DrawReq) error {
 	if req.ActivityId == 0 {
 		return errors.New("活动ID不能为空")
 	}
+	if req.DrawNum <= 0 {
+		return errors.New("抽奖次数必须大于0")
+	}
+	if len(req.ClientSeed) > 64 {
+		return errors.New("客户端种子不能超过64个字符")
+	}
+	if req.ItemId != 0 && req.Currency != types.CurrencyItem {
+		return errors.New("指定物品时货币必须为item")
+	}
+	if req.Free && req.DrawNum != 1 {
+		return errors.New("免费抽奖只能单抽")
+	}
 	// 其他校验逻辑
 	return nil
 }
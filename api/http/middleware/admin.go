@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/linchengzhi/lottery/domain/cerror"
+	"github.com/linchengzhi/lottery/util"
+)
+
+// AdminAuthMiddleware 校验管理接口令牌，未配置令牌时拒绝所有请求
+func AdminAuthMiddleware(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" || c.GetHeader("admin_token") != token {
+			util.RespondErr(c, cerror.ErrForbidden)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
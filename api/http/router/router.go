@@ -5,11 +5,12 @@ import (
 	"github.com/go-redis/redis/v8"
 	"github.com/linchengzhi/lottery/api/http/handler"
 	"github.com/linchengzhi/lottery/api/http/middleware"
+	"github.com/linchengzhi/lottery/domain/dto"
 	"github.com/linchengzhi/lottery/usecase"
 	"go.uber.org/zap"
 )
 
-func SetRoutes(uc usecase.UcAll, log *zap.Logger, gin *gin.Engine, rdb *redis.Client) {
+func SetRoutes(uc usecase.UcAll, conf *dto.Config, log *zap.Logger, gin *gin.Engine, rdb *redis.Client) {
 	// All Public APIs
 	publicRouter := gin.Group("")
 
@@ -32,8 +33,15 @@ func SetRoutes(uc usecase.UcAll, log *zap.Logger, gin *gin.Engine, rdb *redis.Cl
 	//	middleware.RequestIdMiddleware(rdb),
 	//)
 
+	// Admin APIs
+	adminRouter := gin.Group("admin")
+	adminRouter.Use(
+		middleware.AdminAuthMiddleware(conf.Admin.Token),
+	)
+
 	NewLotteryRouter(uc, log, publicRouter)
 	NewAssetRouter(uc, log, publicRouter)
+	NewAdminRouter(uc, conf, log, adminRouter)
 }
 
 func NewLotteryRouter(uc usecase.UcAll, log *zap.Logger, public *gin.RouterGroup) {
@@ -42,6 +50,13 @@ func NewLotteryRouter(uc usecase.UcAll, log *zap.Logger, public *gin.RouterGroup
 	pu := public.Group("lottery")
 	pu.POST("draw", Handle(ud.DrawLottery))
 	pu.GET("prize/list", Handle(ud.ListPrize))
+	pu.GET("seed/list", Handle(ud.ListSeed))
+	pu.GET("verify", Handle(ud.Verify))
+	pu.GET("odds", Handle(ud.Odds))
+	pu.GET("box", Handle(ud.Box))
+	pu.POST("box/reset", Handle(ud.ResetBox))
+	pu.GET("spark", Handle(ud.Spark))
+	pu.POST("spark/redeem", Handle(ud.RedeemSpark))
 }
 
 func NewAssetRouter(uc usecase.UcAll, log *zap.Logger, public *gin.RouterGroup) {
@@ -51,3 +66,13 @@ func NewAssetRouter(uc usecase.UcAll, log *zap.Logger, public *gin.RouterGroup)
 	pu.GET("get", Handle(ud.GetAsset))
 	pu.GET("item/list", Handle(ud.ListItem))
 }
+
+func NewAdminRouter(uc usecase.UcAll, conf *dto.Config, log *zap.Logger, admin *gin.RouterGroup) {
+	ud := handler.NewAdminHandler(uc.LotteryUc, conf.Path, log)
+
+	pu := admin.Group("lottery")
+	pu.POST("reload", Handle(ud.ReloadLottery))
+	pu.POST("seed/rotate", Handle(ud.RotateSeed))
+	pu.GET("replay", Handle(ud.ReplayDraw))
+	pu.GET("experiment", Handle(ud.ExperimentReport))
+}
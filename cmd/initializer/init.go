@@ -14,6 +14,7 @@ import (
 	"github.com/linchengzhi/lottery/repository/mysql_repo"
 	"github.com/linchengzhi/lottery/repository/redis_repo"
 	"github.com/linchengzhi/lottery/usecase"
+	"github.com/linchengzhi/lottery/usecase/lottery_uc"
 	"go.uber.org/zap"
 	"runtime"
 )
@@ -146,13 +147,22 @@ func (app *App) initRepositories() {
 }
 
 func (app *App) initUsecases() {
-	app.UcAll = usecase.NewUcAll(app.Log, app.GPool, app.RepoMysql, app.RepoRedis, app.RedisStream)
+	if app.Conf.Rng.Mode == lottery_uc.RngModeSeeded {
+		app.Log.Warn("抽奖使用 seeded 随机数模式，种子可预测，仅用于测试", zap.Int64("seed", app.Conf.Rng.Seed))
+	}
+	rng := lottery_uc.NewRng(app.Conf.Rng)
+	userAttr := lottery_uc.NewUserAttrProvider(app.Conf.UserAttr, app.RepoMysql)
+	app.UcAll = usecase.NewUcAll(app.Log, app.GPool, app.RepoMysql, app.RepoRedis, app.RedisStream, rng, userAttr)
 }
 
-// 初始化活动
+// 初始化活动，单个活动失败不影响其他活动
 func (app *App) InitActivity() {
-	err := app.UcAll.LotteryUc.SetPrizePool(context.Background(), app.Conf.Lottery)
-	if err != nil {
-		app.Log.Error("初始化活动失败", zap.Any("err", err))
+	versions := app.UcAll.LotteryUc.ReloadPrizePool(context.Background(), app.Conf.Lottery)
+	for _, v := range versions {
+		if v.Err != "" {
+			app.Log.Error("初始化活动失败", zap.Int64("activityId", v.ActivityId), zap.String("err", v.Err), zap.Any("errors", v.Errors))
+			continue
+		}
+		app.Log.Info("初始化活动成功", zap.Int64("activityId", v.ActivityId), zap.Int64("version", v.Version))
 	}
 }
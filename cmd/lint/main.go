@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/linchengzhi/lottery/Infra/config"
+	"github.com/linchengzhi/lottery/usecase/lottery_uc"
+	"os"
+)
+
+// 校验活动配置文件，文件可以是完整的服务配置或单个活动配置，有错误时退出码为1
+// go run ./cmd/lint config/config_dev.yaml
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: lint <config.yaml>...")
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, path := range flag.Args() {
+		if !lint(path) {
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// lint 校验一个配置文件，输出所有错误，没有错误时返回true
+func lint(path string) bool {
+	confs, err := config.NewLotteryConf(path)
+	if err != nil {
+		fmt.Printf("%s: 读取配置失败: %v\n", path, err)
+		return false
+	}
+
+	ok := true
+	seen := make(map[int64]int)
+	for i, conf := range confs {
+		if first, exist := seen[conf.ActivityId]; exist {
+			fmt.Printf("%s: lottery[%d].activity_id: 活动 %d 与 lottery[%d] 重复\n", path, i, conf.ActivityId, first)
+			ok = false
+		}
+		seen[conf.ActivityId] = i
+		for _, e := range lottery_uc.ValidateLotteryConf(conf) {
+			fmt.Printf("%s: 活动 %d: %s\n", path, conf.ActivityId, e)
+			ok = false
+		}
+	}
+	if ok {
+		fmt.Printf("%s: %d 个活动配置正确\n", path, len(confs))
+	}
+	return ok
+}
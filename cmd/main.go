@@ -49,7 +49,7 @@ func main() {
 	gob.Register(entity.User{})
 
 	// 设置路由
-	router.SetRoutes(app.UcAll, app.Log, g, app.RedisDb)
+	router.SetRoutes(app.UcAll, app.Conf, app.Log, g, app.RedisDb)
 
 	// 创建 HTTP 服务器
 	srv := &http.Server{
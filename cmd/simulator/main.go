@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/linchengzhi/lottery/Infra/config"
+	"github.com/linchengzhi/lottery/Infra/logger"
+	"github.com/linchengzhi/lottery/domain/dto"
+	"github.com/linchengzhi/lottery/domain/types"
+	"github.com/linchengzhi/lottery/usecase/lottery_uc"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// 离线模拟抽奖，使用与线上相同的奖池实现
+// go run ./cmd/simulator -f config/config_dev.yaml -activity 12345 -variant a -n 1000000 -batch 10
+var (
+	confPath   = flag.String("f", "config/config_dev.yaml", "活动配置文件，完整服务配置或单个活动配置")
+	activityId = flag.Int64("activity", 0, "活动ID，为0时使用文件中的第一个活动")
+	drawTotal  = flag.Int64("n", 1000000, "模拟总抽数")
+	batch      = flag.Int64("batch", 1, "每次请求的抽数，例如10连")
+	seed       = flag.Int64("seed", 0, "随机种子，为0时使用当前时间，相同种子结果相同")
+	segment    = flag.String("segment", "", "模拟的用户分群，为空时模拟未匹配任何分群的用户")
+	variant    = flag.String("variant", "", "模拟的实验分组，活动配置了实验分组时必须指定")
+)
+
+func main() {
+	flag.Parse()
+	if *drawTotal <= 0 || *batch <= 0 {
+		exit("n 和 batch 必须大于0")
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	confs, err := config.NewLotteryConf(*confPath)
+	if err != nil {
+		exit("读取配置失败: %v", err)
+	}
+	conf, ok := findConf(confs, *activityId)
+	if !ok {
+		exit("活动 %d 不存在", *activityId)
+	}
+	if conf.Mode == types.ActivityModeBox {
+		exit("活动 %d 为箱子模式，概率固定，无需模拟", conf.ActivityId)
+	}
+	// 阶梯模式每次请求的奖池、价格和抽数都不同，单一奖池的模拟结果没有意义
+	if conf.Mode == types.ActivityModeStepUp {
+		exit("活动 %d 为阶梯模式，各阶梯奖池不同，暂不支持模拟", conf.ActivityId)
+	}
+	conf = subConf(conf)
+	log, _ := logger.New(nil)
+	puc, err := lottery_uc.NewPrizePoolUc(log, conf)
+	if err != nil {
+		exit("创建奖池失败: %v", err)
+	}
+
+	result := simulate(puc, conf)
+	report(puc.Odds(context.Background()), conf, result)
+}
+
+func findConf(confs []dto.LotteryConf, activityId int64) (dto.LotteryConf, bool) {
+	for _, conf := range confs {
+		if activityId == 0 || conf.ActivityId == activityId {
+			return conf, true
+		}
+	}
+	return dto.LotteryConf{}, false
+}
+
+// subConf 按 -segment 和 -variant 生成与线上分群、实验分组奖池相同的配置，分组在分群之后应用
+func subConf(conf dto.LotteryConf) dto.LotteryConf {
+	if *segment != "" {
+		found := false
+		for _, seg := range conf.Segments {
+			if seg.Name == *segment {
+				conf.StarLevels = seg.StarLevels
+				found = true
+			}
+		}
+		if !found {
+			exit("活动 %d 不存在分群 %s", conf.ActivityId, *segment)
+		}
+	} else if len(conf.Segments) > 0 {
+		fmt.Println("注意: 活动配置了用户分群，当前模拟未匹配任何分群的用户，可用 -segment 指定分群")
+	}
+	conf.Segments = nil
+
+	if len(conf.Variants) > 0 && *variant == "" {
+		ids := make([]string, 0, len(conf.Variants))
+		for _, v := range conf.Variants {
+			ids = append(ids, v.Id)
+		}
+		exit("活动 %d 配置了实验分组 %v，全部用户都属于某个分组，需用 -variant 指定", conf.ActivityId, ids)
+	}
+	if *variant != "" {
+		found := false
+		for _, v := range conf.Variants {
+			if v.Id == *variant {
+				if len(v.StarLevels) > 0 {
+					conf.StarLevels = v.StarLevels
+				}
+				if len(v.Pricing) > 0 {
+					conf.Pricing = v.Pricing
+				}
+				found = true
+			}
+		}
+		if !found {
+			exit("活动 %d 不存在实验分组 %s", conf.ActivityId, *variant)
+		}
+	}
+	conf.Variants = nil
+	return conf
+}
+
+type simResult struct {
+	draws        int64
+	prizeCount   map[int64]int64 // 奖品ID->抽中次数
+	levelCount   map[int]int64   // 星级->抽中次数
+	topGaps      []int64         // 每次抽中最高星级时距上次的抽数
+	featuredGaps []int64         // 每次抽中UP奖品时距上次的抽数
+	batchFired   int64           // 多连保底触发次数
+}
+
+func simulate(puc lottery_uc.IPrizePoolUc, conf dto.LotteryConf) *simResult {
+	prizeLevel := make(map[int64]int)
+	topLevel := 0
+	featured := make(map[int64]bool)
+	for _, level := range conf.StarLevels {
+		for _, prize := range level.Prizes {
+			if _, ok := prizeLevel[prize.Id]; !ok {
+				prizeLevel[prize.Id] = level.Level
+			}
+		}
+		if level.Level > topLevel {
+			topLevel = level.Level
+			featured = make(map[int64]bool)
+			for _, id := range level.Featured {
+				featured[id] = true
+			}
+		}
+	}
+
+	rng := lottery_uc.NewSeededRng(*seed)
+	s, _ := rng.NewSeed()
+	r := rng.New(s)
+
+	res := &simResult{prizeCount: make(map[int64]int64), levelCount: make(map[int]int64)}
+	state := new(dto.DrawState)
+	sinceTop, sinceFeatured := int64(0), int64(0)
+	ctx := context.Background()
+	for res.draws < *drawTotal {
+		data, err := puc.RandomPrizes(ctx, 0, *batch, state, r)
+		if err != nil {
+			exit("抽奖失败: %v", err)
+		}
+		res.batchFired += data.BatchGuarantee
+		for _, item := range data.Prizes {
+			res.draws++
+			sinceTop++
+			sinceFeatured++
+			res.prizeCount[item.Id]++
+			level := prizeLevel[item.Id]
+			res.levelCount[level]++
+			if level == topLevel {
+				res.topGaps = append(res.topGaps, sinceTop)
+				sinceTop = 0
+			}
+			if featured[item.Id] {
+				res.featuredGaps = append(res.featuredGaps, sinceFeatured)
+				sinceFeatured = 0
+			}
+		}
+	}
+	return res
+}
+
+func report(odds *dto.OddsResp, conf dto.LotteryConf, res *simResult) {
+	fmt.Printf("活动 %d  分群 %q  实验分组 %q  总抽数 %d  每次 %d 抽  种子 %d\n", conf.ActivityId, *segment, *variant, res.draws, *batch, *seed)
+	if conf.Pity.Hard > 0 || conf.Pity.Soft > 0 || conf.BatchGuarantee.BatchSize > 0 || hasFeatured(odds) {
+		fmt.Println("注意: 已开启保底或UP，观测分布偏离基础权重属于预期，卡方检验仅供参考")
+	}
+
+	fmt.Println("\n星级分布")
+	fmt.Printf("%8s %12s %12s %12s\n", "星级", "次数", "观测", "配置")
+	for _, level := range odds.Levels {
+		count := res.levelCount[level.Level]
+		fmt.Printf("%8d %12d %11.4f%% %11.4f%%\n", level.Level, count, percent(count, res.draws), level.Rate*100)
+	}
+
+	fmt.Println("\n奖品分布")
+	fmt.Printf("%8s %8s %12s %12s %12s\n", "奖品", "星级", "次数", "观测", "配置")
+	chi, df := 0.0, -1
+	for _, level := range odds.Levels {
+		for _, prize := range level.Prizes {
+			count := res.prizeCount[prize.Id]
+			fmt.Printf("%8d %8d %12d %11.4f%% %11.4f%%\n", prize.Id, level.Level, count, percent(count, res.draws), prize.Rate*100)
+			expected := prize.Rate * float64(res.draws)
+			if expected > 0 {
+				chi += math.Pow(float64(count)-expected, 2) / expected
+				df++
+			}
+		}
+	}
+	if df > 0 {
+		fmt.Printf("\n卡方检验  chi2=%.2f  自由度=%d  p=%.4f\n", chi, df, chiSquarePValue(chi, df))
+	}
+
+	// 按每次请求的抽数计价，有多连优惠时使用优惠价
+	price := conf.PriceTable()[0]
+	currency := price.Currency
+	if currency == types.CurrencyItem {
+		currency = fmt.Sprintf("物品%d", price.ItemId)
+	}
+	cost := price.Cost(*batch)
+	perDraw := float64(cost) / float64(*batch)
+	fmt.Printf("\n最高星级（花费按 %s %d 抽 %d 计算，单抽 %.2f）\n", currency, *batch, cost, perDraw)
+	printGaps(res.topGaps, odds.Pity.ExpectedDraws, perDraw)
+	if hasFeatured(odds) {
+		fmt.Println("\nUP奖品")
+		printGaps(res.featuredGaps, odds.Pity.ExpectedFeaturedDraws, perDraw)
+	}
+	if conf.BatchGuarantee.BatchSize > 0 {
+		fmt.Printf("\n多连保底触发 %d 次\n", res.batchFired)
+	}
+	if conf.Fallback.Id > 0 {
+		fmt.Println("\n限量奖品库存未模拟，库存不足时的替代奖品不计入分布")
+	}
+}
+
+func hasFeatured(odds *dto.OddsResp) bool {
+	for _, level := range odds.Levels {
+		for _, prize := range level.Prizes {
+			if prize.Featured {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// printGaps 输出抽中所需抽数的分布和平均花费
+func printGaps(gaps []int64, expected float64, price float64) {
+	if len(gaps) == 0 {
+		fmt.Println("  未抽中")
+		return
+	}
+	sum := int64(0)
+	for _, g := range gaps {
+		sum += g
+	}
+	mean := float64(sum) / float64(len(gaps))
+	sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+	fmt.Printf("  抽中 %d 次  平均 %.2f 抽(理论 %.2f)  平均花费 %.0f\n", len(gaps), mean, expected, mean*price)
+	fmt.Printf("  P50=%d  P90=%d  P99=%d  MAX=%d\n",
+		percentile(gaps, 0.5), percentile(gaps, 0.9), percentile(gaps, 0.99), gaps[len(gaps)-1])
+}
+
+// percentile 已排序数据的百分位数
+func percentile(sorted []int64, p float64) int64 {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func percent(count, total int64) float64 {
+	return float64(count) / float64(total) * 100
+}
+
+// chiSquarePValue 卡方分布右尾概率，使用 Wilson-Hilferty 正态近似
+func chiSquarePValue(chi float64, df int) float64 {
+	k := float64(df)
+	z := (math.Cbrt(chi/k) - (1 - 2/(9*k))) / math.Sqrt(2/(9*k))
+	return 0.5 * math.Erfc(z/math.Sqrt2)
+}
+
+func exit(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
@@ -8,6 +8,7 @@ var (
 	ErrParam      = NewError(10004, "参数错误")
 	ErrTimeout    = NewError(10005, "请求超时")
 	ErrDuplicate  = NewError(10006, "重复请求，请刷新后重试")
+	ErrForbidden  = NewError(10007, "没有权限")
 )
 
 // account
@@ -23,6 +24,27 @@ var (
 	ErrLotteryConfig  = NewError(12001, "抽奖配置错误，请检查")
 	ErrLotteryNoPrize = NewError(12002, "抽奖错误，没有奖品")
 	ErrLotteryNoAct   = NewError(12003, "抽奖活动不存在，请刷新")
+	ErrLotteryNoStart = NewError(12004, "抽奖活动未开始")
+	ErrLotteryPaused  = NewError(12005, "抽奖活动已暂停")
+	ErrLotteryEnded   = NewError(12006, "抽奖活动已结束")
+	ErrSeedNotReveal  = NewError(12007, "服务端种子尚未公开，请稍候验证")
+	ErrLotteryNoDraw  = NewError(12008, "抽奖记录不存在")
+	ErrDrawNumLimit   = NewError(12009, "单次抽奖次数超过上限")
+	ErrDailyLimit     = NewError(12010, "今日抽奖次数已达上限")
+	ErrTotalLimit     = NewError(12011, "活动抽奖次数已达上限")
+	ErrCurrencyLimit  = NewError(12012, "活动不支持该货币或物品")
+	ErrNoSegment      = NewError(12013, "奖池分群不存在")
+	ErrBoxNotEnough   = NewError(12014, "箱子剩余奖品不足")
+	ErrBoxNotReset    = NewError(12015, "抽出大奖后才能重置箱子")
+	ErrNotBox         = NewError(12016, "活动不是箱子模式")
+	ErrStepDrawNum    = NewError(12017, "抽数与当前阶梯不一致")
+	ErrNoStep         = NewError(12018, "阶梯不存在")
+	ErrNoSpark        = NewError(12019, "活动未开启积分兑换")
+	ErrSparkLess      = NewError(12020, "兑换积分不足")
+	ErrSparkPrize     = NewError(12021, "奖品不在兑换列表中")
+	ErrNoFree         = NewError(12022, "活动未开启免费抽奖")
+	ErrFreeUsed       = NewError(12023, "免费抽奖次数已用完")
+	ErrNoVariant      = NewError(12024, "实验分组不存在")
 )
 
 // asset
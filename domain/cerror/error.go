@@ -40,6 +40,11 @@ func (e *CustomError) AddMsg(msg string) *CustomError {
 	}
 }
 
+// Unwrap 返回包装的原始错误，支持 errors.Is/As
+func (e *CustomError) Unwrap() error {
+	return e.err
+}
+
 func (e *CustomError) GetCode() int {
 	return e.code
 }
@@ -9,10 +9,53 @@ type AwardStream struct {
 }
 
 type PrizeData struct {
-	UserId     int64   `json:"user_id"`
-	ActivityId int64   `json:"activity_id"`
-	Prizes     []*Item `json:"prize_ids"`
-	Amount     int64   `json:"amount"`
+	UserId         int64           `json:"user_id"`
+	ActivityId     int64           `json:"activity_id"`
+	PoolVersion    int64           `json:"pool_version"` // 抽奖使用的奖池版本
+	Segment        string          `json:"segment"`      // 抽奖使用的分群奖池，默认奖池为空
+	Step           int             `json:"step"`         // 阶梯模式抽奖使用的阶梯，从1开始
+	Variant        string          `json:"variant"`      // 抽奖使用的实验分组
+	TopNum         int64           `json:"top_num"`      // 抽中最高星级的个数
+	Spark          int64           `json:"spark"`        // 本次抽奖获得的兑换积分，发奖时计入
+	Prizes         []*Item         `json:"prize_ids"`
+	Amount         int64           `json:"amount"`          // 实际支付的价格
+	Currency       string          `json:"currency"`        // 实际支付的货币
+	ItemId         int64           `json:"item_id"`         // 使用物品支付时的物品ID
+	State          *DrawState      `json:"state"`           // 抽奖后的用户状态
+	BatchGuarantee int64           `json:"batch_guarantee"` // 触发多连保底的次数
+	Stock          map[int64]int64 `json:"stock"`           // 扣减的限量奖品库存，回滚时归还
+	Fair           *FairData       `json:"fair"`            // 可验证公平的抽奖参数
+	Limit          *LimitData      `json:"limit"`           // 占用的抽数限制，回滚时撤销
+	Free           *FreeData       `json:"free"`            // 免费抽奖占用的周期，付费抽奖为 nil
+
+	Duplicate map[int64]*DuplicateConf `json:"duplicate"` // 抽中的唯一奖品的转换规则，发奖时使用
+	Converted []*Conversion            `json:"converted"` // 发奖时重复奖品的转换结果
+	Content   map[int64]*PrizeContent  `json:"content"`   // 抽中的组合奖品的内容，发奖时使用
+}
+
+// 抽奖占用的抽数限制
+type LimitData struct {
+	Day string `json:"day"` // 计入的日期，按活动时区
+	Num int64  `json:"num"`
+}
+
+// 免费抽奖占用的周期
+type FreeData struct {
+	Period string `json:"period"` // 每日免费为活动时区的日期，按小时免费为空
+}
+
+// 可验证公平的抽奖参数，抽奖种子为 HMAC(服务端种子, 客户端种子:用户ID:序号)
+type FairData struct {
+	Seed              string `json:"seed"`               // 抽奖种子，由此生成本次抽奖的随机数
+	Epoch             int64  `json:"epoch"`              // 服务端种子周期
+	SeedHash          string `json:"seed_hash"`          // 服务端种子摘要
+	ClientSeed        string `json:"client_seed"`        // 客户端种子
+	Nonce             int64  `json:"nonce"`              // 抽奖序号，即抽奖前的累计抽数
+	Pity              int64  `json:"pity"`               // 抽奖前的保底计数
+	FeaturedGuarantee bool   `json:"featured_guarantee"` // 抽奖前是否UP保底
+
+	Box  *BoxState `json:"box,omitempty"`  // 抽奖前的箱子状态，箱子模式使用
+	Step int64     `json:"step,omitempty"` // 抽奖前已完成的阶梯数，阶梯模式使用
 }
 
 type Item struct {
@@ -25,13 +68,43 @@ type Prize struct {
 	Id     int64 `json:"id" yaml:"id"`         // 奖品ID  固定为一个
 	Num    int64 `json:"num" yaml:"num"`       // 奖品数量
 	Weight int64 `json:"weight" yaml:"weight"` // 奖品的权重，用于随机
+	Stock  int64 `json:"stock" yaml:"stock"`   // 限量奖品的总库存，0表示不限量
+	Unique bool  `json:"unique" yaml:"unique"` // 唯一奖品，用户已拥有时按 duplicate 转换
+
+	Duplicate *DuplicateConf `json:"duplicate" yaml:"duplicate"` // 重复奖品的转换规则
+	Content   *PrizeContent  `json:"content" yaml:"content"`     // 组合奖品的内容，配置后发放内容而不是奖品ID
+}
+
+// 组合奖品的内容，每个奖品发放全部物品和货币，例如500金币+2个物品101
+type PrizeContent struct {
+	Items   []Item `json:"items" yaml:"items"`
+	Gold    int64  `json:"gold" yaml:"gold"`
+	Stone   int64  `json:"stone" yaml:"stone"`
+	Crystal int64  `json:"crystal" yaml:"crystal"`
+}
+
+// 重复奖品转换规则，每个重复奖品转换为 num 个碎片物品或货币，item_id 和 currency 二选一
+type DuplicateConf struct {
+	ItemId   int64  `json:"item_id" yaml:"item_id"`   // 碎片物品ID
+	Currency string `json:"currency" yaml:"currency"` // gold、stone 或 crystal
+	Num      int64  `json:"num" yaml:"num"`
+}
+
+// 重复奖品的转换结果
+type Conversion struct {
+	PrizeId  int64  `json:"prize_id"`
+	Num      int64  `json:"num"`      // 被转换的奖品数量
+	ItemId   int64  `json:"item_id"`  // 转换得到的物品ID
+	Currency string `json:"currency"` // 转换得到的货币
+	Amount   int64  `json:"amount"`   // 转换得到的物品或货币数量
 }
 
 // 星级奖品
 type StarLevel struct {
-	Level  int      `json:"level" yaml:"level"`   // 星级，例如1，2，3
-	Weight int64    `json:"weight" yaml:"weight"` // 星级的权重 weight/sum(weight)
-	Prizes []*Prize `json:"prizes" yaml:"prizes"` // 该星级下的奖品列表
+	Level    int      `json:"level" yaml:"level"`       // 星级，例如1，2，3
+	Weight   int64    `json:"weight" yaml:"weight"`     // 星级的权重 weight/sum(weight)
+	Prizes   []*Prize `json:"prizes" yaml:"prizes"`     // 该星级下的奖品列表
+	Featured []int64  `json:"featured" yaml:"featured"` // UP奖品ID，仅最高星级有效
 }
 
 // 奖池配置
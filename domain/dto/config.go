@@ -2,6 +2,7 @@ package dto
 
 import (
 	"github.com/linchengzhi/lottery/Infra/logger"
+	"time"
 )
 
 type Config struct {
@@ -13,8 +14,27 @@ type Config struct {
 	Mysql      Mysql          `yaml:"mysql"`
 	Redis      Redis          `yaml:"redis"`
 	Stream     []RedisStream  `yaml:"redis_stream"`
-	Lottery    LotteryConf    `yaml:"lottery"`
+	Lottery    []LotteryConf  `yaml:"lottery"` // 活动列表
 	JaegerConf JaegerConf     `json:"jaeger" yaml:"jaeger"`
+	Admin      Admin          `yaml:"admin"`
+	Rng        RngConf        `yaml:"rng"`
+	UserAttr   UserAttrConf   `yaml:"user_attr"` // 用户属性来源，用于分群奖池
+	Path       string         `yaml:"-"`         // 配置文件路径，用于热加载
+}
+
+type Admin struct {
+	Token string `yaml:"token"` // 管理接口令牌，为空时禁用管理接口
+}
+
+// 用户属性来源，table 从 user_profile 表读取，默认使用；header 从网关传入的请求头读取，网关需删除客户端传入的同名请求头
+type UserAttrConf struct {
+	Provider string `yaml:"provider"`
+}
+
+// 抽奖随机数配置
+type RngConf struct {
+	Mode string `yaml:"mode"` // crypto 或 seeded，默认 crypto
+	Seed int64  `yaml:"seed"` // seeded 模式的主种子，相同主种子生成相同的种子序列，仅用于测试
 }
 
 type HTTP struct {
@@ -48,8 +68,151 @@ type RedisStream struct {
 
 type LotteryConf struct {
 	ActivityId int64        `json:"activity_id" yaml:"activity_id"`
-	Price      int64        `json:"price" yaml:"price"`
+	Version    int64        `json:"-" yaml:"-"`                   // 奖池版本，加载时生成
+	StartTime  time.Time    `json:"start_time" yaml:"start_time"` // 开始时间，为空表示立即开始
+	EndTime    time.Time    `json:"end_time" yaml:"end_time"`     // 结束时间，为空表示不结束
+	State      string       `json:"state" yaml:"state"`           // 手动设置的状态，目前只支持 paused 暂停
+	Price      int64        `json:"price" yaml:"price"`           // 单抽原石价格，未配置 pricing 时使用
+	Pricing    []PriceConf  `json:"pricing" yaml:"pricing"`       // 价格表，按顺序选择第一个余额足够的货币
 	StarLevels []*StarLevel `json:"star_levels" yaml:"star_levels"`
+	Pity       PityConf     `json:"pity" yaml:"pity"`
+
+	BatchGuarantee BatchGuaranteeConf `json:"batch_guarantee" yaml:"batch_guarantee"`
+	Fallback       Item               `json:"fallback" yaml:"fallback"`           // 限量奖品库存不足时发放的奖品
+	FeaturedRate   int64              `json:"featured_rate" yaml:"featured_rate"` // 抽中最高星级时获得UP奖品的概率(百分比)，默认50
+	Limit          LimitConf          `json:"limit" yaml:"limit"`
+	Segments       []SegmentConf      `json:"segments" yaml:"segments"` // 用户分群奖池，按顺序匹配，未匹配时使用 star_levels
+	Mode           string             `json:"mode" yaml:"mode"`         // 抽奖模式，为空时按星级权重抽取，box 为箱子模式
+	Box            BoxConf            `json:"box" yaml:"box"`           // 箱子模式的奖品
+	Steps          []StepConf         `json:"steps" yaml:"steps"`       // 阶梯模式的阶梯，按顺序循环
+	Spark          SparkConf          `json:"spark" yaml:"spark"`       // 兑换积分
+	Free           FreeConf           `json:"free" yaml:"free"`         // 免费抽奖
+	Variants       []VariantConf      `json:"variants" yaml:"variants"` // A/B实验分组，按用户ID哈希分配
+}
+
+// A/B实验分组，分组替换星级奖品或价格，其他配置与活动共用
+type VariantConf struct {
+	Id         string       `json:"id" yaml:"id"`                   // 分组ID，记录在抽奖记录中
+	Percent    int64        `json:"percent" yaml:"percent"`         // 流量百分比，全部分组之和为100
+	StarLevels []*StarLevel `json:"star_levels" yaml:"star_levels"` // 为空时使用活动的 star_levels
+	Pricing    []PriceConf  `json:"pricing" yaml:"pricing"`         // 为空时使用活动的价格
+}
+
+// 免费抽奖配置，每个周期一次免费单抽，不扣除资产，daily 和 hours 只能配置一个
+type FreeConf struct {
+	Daily    bool   `json:"daily" yaml:"daily"`       // 每日一次，按 timezone 的零点重置
+	Hours    int64  `json:"hours" yaml:"hours"`       // 每N小时一次，从上次免费抽奖开始计算
+	Timezone string `json:"timezone" yaml:"timezone"` // 每日重置使用的时区，默认使用 limit.timezone
+}
+
+// 兑换积分配置，每抽获得积分，积分达到兑换价格后可兑换列表中的任一奖品，活动结束时积分清零
+type SparkConf struct {
+	PerDraw int64  `json:"per_draw" yaml:"per_draw"` // 每抽获得的积分，0为不开启
+	Cost    int64  `json:"cost" yaml:"cost"`         // 兑换一次需要的积分
+	Prizes  []Item `json:"prizes" yaml:"prizes"`     // 可兑换的奖品
+}
+
+// 阶梯模式的一个阶梯，每次抽奖完成一个阶梯
+type StepConf struct {
+	DrawNum    int64        `json:"draw_num" yaml:"draw_num"`       // 该阶梯的抽数，抽奖请求的抽数必须与之相同
+	Pricing    []PriceConf  `json:"pricing" yaml:"pricing"`         // 该阶梯的价格表
+	StarLevels []*StarLevel `json:"star_levels" yaml:"star_levels"` // 替换活动的星级奖品，为空时使用活动的 star_levels
+	MinLevel   int          `json:"min_level" yaml:"min_level"`     // 该阶梯至少抽中一个不低于该星级的奖品，0为不保底
+}
+
+// 箱子模式配置，每个用户一箱相同的奖品，抽出的奖品不放回，抽出大奖后可以重置为新的一箱
+type BoxConf struct {
+	Prizes     []BoxPrize `json:"prizes" yaml:"prizes"`
+	GrandPrize int64      `json:"grand_prize" yaml:"grand_prize"` // 大奖ID
+}
+
+// 箱子中的奖品
+type BoxPrize struct {
+	Id    int64 `json:"id" yaml:"id"`       // 奖品ID
+	Num   int64 `json:"num" yaml:"num"`     // 每次抽中发放的数量
+	Count int64 `json:"count" yaml:"count"` // 箱子中的个数
+}
+
+// 用户分群奖池，条件同时满足时使用该分群的星级奖品，其他配置与活动共用
+type SegmentConf struct {
+	Name        string       `json:"name" yaml:"name"`                   // 分群名称，记录在抽奖记录中
+	NewUserDays int64        `json:"new_user_days" yaml:"new_user_days"` // 注册不超过N天的新用户，0表示不限制
+	MinVip      int64        `json:"min_vip" yaml:"min_vip"`             // 最低VIP等级，0表示不限制
+	MaxVip      int64        `json:"max_vip" yaml:"max_vip"`             // 最高VIP等级，0表示不限制
+	StarLevels  []*StarLevel `json:"star_levels" yaml:"star_levels"`
+}
+
+// Match 判断用户在now时刻是否属于该分群，用户属性未知时不属于任何分群
+func (s SegmentConf) Match(attr *UserAttr, now time.Time) bool {
+	if attr == nil {
+		return false
+	}
+	if s.NewUserDays > 0 {
+		if attr.RegisterTime.IsZero() || now.Sub(attr.RegisterTime) >= time.Duration(s.NewUserDays)*24*time.Hour {
+			return false
+		}
+	}
+	if s.MinVip > 0 && attr.Vip < s.MinVip {
+		return false
+	}
+	if s.MaxVip > 0 && attr.Vip > s.MaxVip {
+		return false
+	}
+	return true
+}
+
+// 一种货币的价格
+type PriceConf struct {
+	Currency string       `json:"currency" yaml:"currency"` // gold、stone、crystal 或 item
+	ItemId   int64        `json:"item_id" yaml:"item_id"`   // 物品ID，货币为 item 时使用
+	Price    int64        `json:"price" yaml:"price"`       // 单抽价格
+	Bundles  []BundleConf `json:"bundles" yaml:"bundles"`   // 多连优惠价
+}
+
+// 多连优惠价，例如10连按9抽计费
+type BundleConf struct {
+	DrawNum int64 `json:"draw_num" yaml:"draw_num"`
+	Price   int64 `json:"price" yaml:"price"` // 多连总价
+}
+
+// Cost 计算drawNum抽的价格，有对应抽数的多连优惠时使用优惠价
+func (p PriceConf) Cost(drawNum int64) int64 {
+	for _, b := range p.Bundles {
+		if b.DrawNum == drawNum {
+			return b.Price
+		}
+	}
+	return p.Price * drawNum
+}
+
+// PriceTable 获取价格表，未配置 pricing 时由 price 生成原石价格
+func (c LotteryConf) PriceTable() []PriceConf {
+	if len(c.Pricing) > 0 {
+		return c.Pricing
+	}
+	return []PriceConf{{Currency: "stone", Price: c.Price}}
+}
+
+// 用户抽奖次数限制，0表示不限制
+type LimitConf struct {
+	Daily      int64  `json:"daily" yaml:"daily"`             // 每个用户每日最多抽数
+	Total      int64  `json:"total" yaml:"total"`             // 每个用户在活动中累计最多抽数
+	PerRequest int64  `json:"per_request" yaml:"per_request"` // 单次请求最多抽数
+	Timezone   string `json:"timezone" yaml:"timezone"`       // 每日重置使用的时区，例如 Asia/Shanghai，默认服务器时区
+}
+
+// 保底配置，保底针对最高星级
+type PityConf struct {
+	Hard      int64   `json:"hard" yaml:"hard"`             // 硬保底，连续hard抽未出最高星级时必出，0表示不启用
+	Soft      int64   `json:"soft" yaml:"soft"`             // 软保底，连续soft抽未出最高星级后，之后每抽提高最高星级权重，0表示不启用
+	SoftStep  int64   `json:"soft_step" yaml:"soft_step"`   // 软保底线性递增，超过soft后每抽增加的最高星级权重
+	SoftSteps []int64 `json:"soft_steps" yaml:"soft_steps"` // 软保底步进表，超过soft后第n抽增加的权重，超出表长使用最后一个，配置后忽略soft_step
+}
+
+// 多连保底配置，每batch_size抽至少有一个奖品达到min_level星级
+type BatchGuaranteeConf struct {
+	BatchSize int64 `json:"batch_size" yaml:"batch_size"` // 每批抽数，例如10连，0表示不启用
+	MinLevel  int   `json:"min_level" yaml:"min_level"`   // 每批保底的最低星级
 }
 
 type JaegerConf struct {
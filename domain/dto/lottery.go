@@ -1,6 +1,9 @@
 package dto
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 type DrawReq struct {
 	RequestId   string     `json:"request_id"`
@@ -8,18 +11,257 @@ type DrawReq struct {
 	UserId      int64      `json:"user_id"`
 	ActivityId  int64      `json:"activity_id"`
 	DrawNum     int64      `json:"draw_num"`
+	ClientSeed  string     `json:"client_seed"` // 客户端种子，参与生成抽奖结果
+	Currency    string     `json:"currency"`    // 指定支付货币，为空时按价格表顺序选择
+	ItemId      int64      `json:"item_id"`     // 指定支付物品，货币为 item 时使用，为空时按价格表顺序选择
+	Free        bool       `json:"free"`        // 使用免费抽奖，只能单抽
 	PrizesData  *PrizeData `json:"prizes_data"`
+	Attr        *UserAttr  `json:"-"` // 网关传入的用户属性，仅 header 来源使用
+}
+
+// 用户属性，用于选择分群奖池
+type UserAttr struct {
+	RegisterTime time.Time `json:"register_time"`
+	Vip          int64     `json:"vip"`
 }
 
 type DrawResp struct {
 	RequestId string     `json:"request_id"`
 	PrizeData *PrizeData `json:"prize_data"`
+	Pity      int64      `json:"pity"` // 当前保底计数
 	Err       error      `json:"err"`
 }
 
+// 用户在活动中的抽奖状态
+type DrawState struct {
+	Pity              int64 `json:"pity"`               // 距上次抽中最高星级的抽数
+	DrawTotal         int64 `json:"draw_total"`         // 累计抽奖次数，持久化时用于判断新旧
+	FeaturedGuarantee bool  `json:"featured_guarantee"` // 上次最高星级未抽中UP，下次最高星级必为UP
+
+	Box  *BoxState `json:"box,omitempty"`  // 箱子模式的剩余奖品，首次抽奖时生成
+	Step int64     `json:"step,omitempty"` // 阶梯模式已完成的阶梯数，当前阶梯为 step % 阶梯数
+}
+
+// 用户当前箱子的状态
+type BoxState struct {
+	Round      int64           `json:"round"`       // 第几箱，从1开始
+	Remaining  map[int64]int64 `json:"remaining"`   // 奖品ID->剩余个数
+	GrandTaken bool            `json:"grand_taken"` // 大奖是否已抽出，抽出后可以重置
+}
+
+// Clone 复制箱子状态，抽奖会修改剩余个数
+func (b *BoxState) Clone() *BoxState {
+	if b == nil {
+		return nil
+	}
+	c := &BoxState{Round: b.Round, GrandTaken: b.GrandTaken, Remaining: make(map[int64]int64, len(b.Remaining))}
+	for id, num := range b.Remaining {
+		c.Remaining[id] = num
+	}
+	return c
+}
+
+type BoxReq struct {
+	ActivityId int64 `json:"activity_id" form:"activity_id"`
+	UserId     int64 `json:"user_id" form:"user_id"`
+}
+
+// 箱子中剩余的奖品
+type BoxResp struct {
+	ActivityId int64      `json:"activity_id"`
+	UserId     int64      `json:"user_id"`
+	Round      int64      `json:"round"`
+	GrandPrize int64      `json:"grand_prize"`
+	GrandTaken bool       `json:"grand_taken"`
+	Left       int64      `json:"left"`  // 剩余奖品总个数
+	Total      int64      `json:"total"` // 一箱的奖品总个数
+	Prizes     []*BoxItem `json:"prizes"`
+}
+
+type BoxItem struct {
+	Id    int64 `json:"id"`
+	Num   int64 `json:"num"`
+	Left  int64 `json:"left"`
+	Count int64 `json:"count"`
+}
+
 type ListPrizeReq struct {
 	ActivityId int64 `json:"activity_id"`
 	UserId     int64 `json:"user_id"`
 	Page       int   `json:"page"`
 	PageSize   int   `json:"page_size"`
 }
+
+// 活动种子周期，种子公开前Seed为空
+type SeedEpoch struct {
+	ActivityId int64      `json:"activity_id"`
+	Epoch      int64      `json:"epoch"`
+	SeedHash   string     `json:"seed_hash"`
+	Seed       string     `json:"seed,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RevealedAt *time.Time `json:"revealed_at,omitempty"`
+}
+
+type SeedReq struct {
+	ActivityId int64 `json:"activity_id" form:"activity_id"`
+}
+
+type VerifyReq struct {
+	ActivityId int64  `json:"activity_id" form:"activity_id"`
+	RequestId  string `json:"request_id" form:"request_id"`
+}
+
+// 抽奖验证结果，Prizes为记录的奖品，Replay为根据种子重新计算的奖品
+type VerifyResp struct {
+	RequestId   string  `json:"request_id"`
+	ActivityId  int64   `json:"activity_id"`
+	UserId      int64   `json:"user_id"`
+	PoolVersion int64   `json:"pool_version"`
+	Segment     string  `json:"segment"`
+	Step        int     `json:"step"`
+	Variant     string  `json:"variant"`
+	Epoch       int64   `json:"epoch"`
+	Seed        string  `json:"seed"`
+	SeedHash    string  `json:"seed_hash"`
+	ClientSeed  string  `json:"client_seed"`
+	Nonce       int64   `json:"nonce"`
+	DrawSeed    string  `json:"draw_seed"`
+	Prizes      []*Item `json:"prizes"`
+	Replay      []*Item `json:"replay"`
+	Match       bool    `json:"match"`
+}
+
+// 概率公示请求，Version和At都为空时查询当前奖池
+type OddsReq struct {
+	ActivityId int64     `json:"activity_id" form:"activity_id"`
+	Version    int64     `json:"version" form:"version"` // 奖池版本
+	At         time.Time `json:"at" form:"at"`           // 查询该时间生效的奖池，RFC3339格式
+	Segment    string    `json:"segment" form:"segment"` // 分群名称，为空时查询默认奖池
+	Step       int       `json:"step" form:"step"`       // 阶梯模式的阶梯，从1开始，为0时查询活动的 star_levels
+	Variant    string    `json:"variant" form:"variant"` // 实验分组，为空时查询活动的 star_levels
+}
+
+// 概率公示，Rate均为单抽概率
+type OddsResp struct {
+	ActivityId  int64        `json:"activity_id"`
+	Version     int64        `json:"version"`
+	EffectiveAt time.Time    `json:"effective_at"` // 版本生效时间
+	Segment     string       `json:"segment"`      // 分群名称，默认奖池为空
+	Segments    []string     `json:"segments"`     // 活动的全部分群
+	Step        int          `json:"step"`         // 阶梯，从1开始，非阶梯奖池为0
+	Steps       []*StepOdds  `json:"steps"`        // 阶梯模式的全部阶梯
+	Variant     string       `json:"variant"`      // 实验分组，活动奖池为空
+	Variants    []string     `json:"variants"`     // 活动的全部实验分组
+	Levels      []*LevelOdds `json:"levels"`
+	Pity        PityOdds     `json:"pity"`
+}
+
+// 阶梯的抽数和价格
+type StepOdds struct {
+	Step     int         `json:"step"`
+	DrawNum  int64       `json:"draw_num"`
+	Pricing  []PriceConf `json:"pricing"`
+	MinLevel int         `json:"min_level"` // 保底星级，0为不保底
+}
+
+type LevelOdds struct {
+	Level  int          `json:"level"`
+	Rate   float64      `json:"rate"` // 不计入保底时的星级概率
+	Prizes []*PrizeOdds `json:"prizes"`
+}
+
+type PrizeOdds struct {
+	Id       int64   `json:"id"`
+	Num      int64   `json:"num"`
+	Rate     float64 `json:"rate"`     // 不计入保底时的奖品概率
+	Featured bool    `json:"featured"` // 是否UP奖品
+	Stock    int64   `json:"stock"`    // 限量奖品总库存，0表示不限量
+}
+
+// 计入保底后的期望，不计入多连保底
+type PityOdds struct {
+	Hard                  int64   `json:"hard"`
+	Soft                  int64   `json:"soft"`
+	TopRate               float64 `json:"top_rate"`                // 计入保底后最高星级的综合概率
+	ExpectedDraws         float64 `json:"expected_draws"`          // 平均多少抽出最高星级
+	FeaturedShare         float64 `json:"featured_share"`          // 计入UP保底后最高星级中UP奖品的占比
+	ExpectedFeaturedDraws float64 `json:"expected_featured_draws"` // 平均多少抽出UP奖品
+}
+
+// 奖池版本，加载失败时Err为失败原因，配置错误时Errors为每个字段的错误
+type PoolVersion struct {
+	ActivityId int64       `json:"activity_id"`
+	Version    int64       `json:"version"`
+	Err        string      `json:"err,omitempty"`
+	Errors     []ConfError `json:"errors,omitempty"`
+}
+
+// 配置字段错误，Path与yaml字段一致，例如 star_levels[2].prizes[0].weight
+type ConfError struct {
+	Path string `json:"path"`
+	Msg  string `json:"msg"`
+}
+
+func (e ConfError) String() string {
+	return e.Path + ": " + e.Msg
+}
+
+type ConfErrors []ConfError
+
+func (e ConfErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, v := range e {
+		msgs = append(msgs, v.String())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+type SparkReq struct {
+	ActivityId int64 `json:"activity_id" form:"activity_id"`
+	UserId     int64 `json:"user_id" form:"user_id"`
+}
+
+// 用户的兑换积分和可兑换的奖品
+type SparkResp struct {
+	ActivityId int64   `json:"activity_id"`
+	UserId     int64   `json:"user_id"`
+	Points     int64   `json:"points"` // 当前积分
+	Cost       int64   `json:"cost"`   // 兑换一次需要的积分
+	Prizes     []*Item `json:"prizes"` // 可兑换的奖品
+}
+
+type RedeemReq struct {
+	ActivityId  int64     `json:"activity_id"`
+	UserId      int64     `json:"user_id"`
+	PrizeId     int64     `json:"prize_id"` // 兑换的奖品ID，必须在兑换列表中
+	RequestId   string    `json:"-"`
+	RequestTime time.Time `json:"-"`
+}
+
+type RedeemResp struct {
+	RequestId string `json:"request_id"`
+	Prize     *Item  `json:"prize"`
+	Points    int64  `json:"points"` // 兑换后剩余积分
+}
+
+type ExperimentReq struct {
+	ActivityId int64 `json:"activity_id" form:"activity_id"`
+}
+
+// A/B实验报告，按抽奖记录统计每个分组
+type ExperimentResp struct {
+	ActivityId int64            `json:"activity_id"`
+	Variants   []*VariantReport `json:"variants"`
+}
+
+type VariantReport struct {
+	Variant   string           `json:"variant"`    // 分组ID，未分组的抽奖为空
+	Percent   int64            `json:"percent"`    // 当前配置的流量百分比
+	Users     int64            `json:"users"`      // 参与用户数
+	Records   int64            `json:"records"`    // 抽奖请求数
+	Draws     int64            `json:"draws"`      // 总抽数
+	FreeDraws int64            `json:"free_draws"` // 免费抽数
+	TopNum    int64            `json:"top_num"`    // 抽中最高星级的个数
+	TopRate   float64          `json:"top_rate"`   // 最高星级的实际单抽概率
+	Spend     map[string]int64 `json:"spend"`      // 各货币的花费，物品为 item:物品ID
+}
@@ -11,12 +11,59 @@ const (
 )
 
 type LotteryDrawRecord struct {
-	ID         int64     `gorm:"primaryKey;autoIncrement;comment:'抽奖记录ID'" json:"id"`
-	ActivityID int64     `gorm:"not null;comment:'活动ID'" json:"activity_id"`
-	UserID     int64     `gorm:"not null;comment:'用户ID'" json:"user_id"`
-	DrawCount  int       `gorm:"not null;default:1;comment:'抽奖次数，例如1次或10次抽奖'" json:"draw_count"`
-	CreatedAt  time.Time `gorm:"not null;comment:'记录创建时间'" json:"created_at"`
-	RequestID  string    `gorm:"size:36;uniqueIndex:uniq_request_id;comment:'请求ID，用于幂等'" json:"request_id"`
+	ID          int64     `gorm:"primaryKey;autoIncrement;comment:'抽奖记录ID'" json:"id"`
+	ActivityID  int64     `gorm:"not null;comment:'活动ID'" json:"activity_id"`
+	UserID      int64     `gorm:"not null;comment:'用户ID'" json:"user_id"`
+	DrawCount   int       `gorm:"not null;default:1;comment:'抽奖次数，例如1次或10次抽奖'" json:"draw_count"`
+	PoolVersion int64     `gorm:"not null;default:0;comment:'奖池版本'" json:"pool_version"`
+	Segment     string    `gorm:"size:32;not null;default:'';comment:'分群奖池'" json:"segment"`
+	Step        int       `gorm:"not null;default:0;comment:'阶梯模式的阶梯，从1开始'" json:"step"`
+	Free        bool      `gorm:"not null;default:false;comment:'是否免费抽奖'" json:"free"`
+	Variant     string    `gorm:"size:32;not null;default:'';index:idx_variant;comment:'实验分组'" json:"variant"`
+	Amount      int64     `gorm:"not null;default:0;comment:'实际支付的价格'" json:"amount"`
+	Currency    string    `gorm:"size:16;not null;default:'';comment:'实际支付的货币'" json:"currency"`
+	ItemID      int64     `gorm:"not null;default:0;comment:'使用物品支付时的物品ID'" json:"item_id"`
+	TopNum      int64     `gorm:"not null;default:0;comment:'抽中最高星级的个数'" json:"top_num"`
+	CreatedAt   time.Time `gorm:"not null;comment:'记录创建时间'" json:"created_at"`
+	RequestID   string    `gorm:"size:36;uniqueIndex:uniq_request_id;comment:'请求ID，用于幂等'" json:"request_id"`
+
+	// 可验证公平的抽奖参数，种子公开后可据此重新计算抽奖结果
+	Epoch          int64  `gorm:"not null;default:0;comment:'服务端种子周期'" json:"epoch"`
+	SeedHash       string `gorm:"size:64;not null;default:'';comment:'服务端种子摘要'" json:"seed_hash"`
+	ClientSeed     string `gorm:"size:64;not null;default:'';comment:'客户端种子'" json:"client_seed"`
+	Nonce          int64  `gorm:"not null;default:0;comment:'抽奖序号'" json:"nonce"`
+	DrawSeed       string `gorm:"size:64;not null;default:'';comment:'抽奖种子，用于重现抽奖'" json:"draw_seed"`
+	PityBefore     int64  `gorm:"not null;default:0;comment:'抽奖前的保底计数'" json:"pity_before"`
+	FeaturedBefore bool   `gorm:"not null;default:false;comment:'抽奖前是否UP保底'" json:"featured_before"`
+	Prizes         string `gorm:"type:json;comment:'抽中的奖品列表'" json:"prizes"`
+	Converted      string `gorm:"type:json;comment:'重复奖品的转换结果'" json:"converted"`
+	BoxBefore      string `gorm:"type:json;comment:'抽奖前的箱子状态'" json:"box_before"`
+}
+
+// LotteryDrawStats 活动抽奖统计
+type LotteryDrawStats struct {
+	Records int64 `json:"records"` // 抽奖请求数
+	Users   int64 `json:"users"`   // 参与用户数
+	Draws   int64 `json:"draws"`   // 总抽数
+	Free    int64 `json:"free"`    // 免费抽数
+}
+
+// LotteryVariantStats 实验分组的抽奖统计
+type LotteryVariantStats struct {
+	Variant string `json:"variant"`
+	Records int64  `json:"records"`
+	Users   int64  `json:"users"`
+	Draws   int64  `json:"draws"`
+	Free    int64  `json:"free"`
+	TopNum  int64  `json:"top_num"`
+}
+
+// LotteryVariantSpend 实验分组在一种货币或物品上的花费
+type LotteryVariantSpend struct {
+	Variant  string `json:"variant"`
+	Currency string `json:"currency"`
+	ItemID   int64  `json:"item_id"`
+	Amount   int64  `json:"amount"`
 }
 
 type ILotteryDrawRecordRepo interface {
@@ -24,4 +71,9 @@ type ILotteryDrawRecordRepo interface {
 	TableName(activityId int64) string
 	Create(ctx context.Context, drawRecord *LotteryDrawRecord, prizes []*dto.Item) error
 	BatchCreate(ctx context.Context, drawRecords []*LotteryDrawRecord, prizeRecords []*LotteryPrizeRecord) error
+	Stats(ctx context.Context, activityId int64) (*LotteryDrawStats, error)
+	// 按实验分组统计抽奖和各货币的花费
+	VariantStats(ctx context.Context, activityId int64) ([]*LotteryVariantStats, []*LotteryVariantSpend, error)
+	// 根据请求ID获取抽奖记录，不存在时返回 nil
+	GetByRequestID(ctx context.Context, activityId int64, requestId string) (*LotteryDrawRecord, error)
 }
@@ -0,0 +1,34 @@
+package entity
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	TNLotteryPoolVersion = "lottery_pool_version"
+)
+
+// LotteryPoolVersion 奖池配置版本，每次配置变化生成一个新版本
+type LotteryPoolVersion struct {
+	ID         int64     `gorm:"primaryKey;autoIncrement;comment:'奖池版本ID'" json:"id"`
+	ActivityID int64     `gorm:"not null;uniqueIndex:uniq_activity_version;comment:'活动ID'" json:"activity_id"`
+	Version    int64     `gorm:"not null;uniqueIndex:uniq_activity_version;comment:'版本号'" json:"version"`
+	Hash       string    `gorm:"size:64;not null;comment:'配置摘要'" json:"hash"`
+	Conf       string    `gorm:"type:json;not null;comment:'奖池配置'" json:"conf"`
+	CreatedAt  time.Time `gorm:"not null;comment:'创建时间'" json:"created_at"`
+}
+
+func (v *LotteryPoolVersion) TableName() string {
+	return TNLotteryPoolVersion
+}
+
+type ILotteryPoolVersionRepo interface {
+	// 获取活动最新版本，不存在时返回 nil
+	Latest(ctx context.Context, activityId int64) (*LotteryPoolVersion, error)
+	// 获取指定版本，不存在时返回 nil
+	Get(ctx context.Context, activityId, version int64) (*LotteryPoolVersion, error)
+	// 获取在at时刻生效的版本，不存在时返回 nil
+	GetAt(ctx context.Context, activityId int64, at time.Time) (*LotteryPoolVersion, error)
+	Create(ctx context.Context, v *LotteryPoolVersion) error
+}
@@ -15,6 +15,7 @@ type LotteryPrizeRecord struct {
 	UserID     int64     `gorm:"not null;comment:'用户ID'" json:"user_id"`
 	PrizeID    int64     `gorm:"not null;comment:'奖品ID'" json:"prize_id"`
 	PrizeNum   int64     `gorm:"not null;comment:'奖品数量'" json:"prize_num"`
+	Content    string    `gorm:"type:json;comment:'组合奖品的内容'" json:"content"`
 	CreatedAt  time.Time `gorm:"autoCreateTime;comment:'创建时间'" json:"created_at"`
 }
 
@@ -0,0 +1,37 @@
+package entity
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	TNLotterySeed = "lottery_seed"
+)
+
+// LotterySeed 活动服务端种子，每个周期一个，公开前只公布种子摘要
+type LotterySeed struct {
+	ID         int64      `gorm:"primaryKey;autoIncrement;comment:'种子ID'" json:"id"`
+	ActivityID int64      `gorm:"not null;uniqueIndex:uniq_activity_epoch;comment:'活动ID'" json:"activity_id"`
+	Epoch      int64      `gorm:"not null;uniqueIndex:uniq_activity_epoch;comment:'种子周期'" json:"epoch"`
+	Seed       string     `gorm:"size:64;not null;comment:'服务端种子，公开前保密'" json:"seed"`
+	SeedHash   string     `gorm:"size:64;not null;comment:'种子摘要 sha256(seed)'" json:"seed_hash"`
+	Revealed   bool       `gorm:"not null;default:false;comment:'种子是否已公开'" json:"revealed"`
+	CreatedAt  time.Time  `gorm:"not null;comment:'创建时间'" json:"created_at"`
+	RevealedAt *time.Time `gorm:"comment:'公开时间'" json:"revealed_at"`
+}
+
+func (s *LotterySeed) TableName() string {
+	return TNLotterySeed
+}
+
+type ILotterySeedRepo interface {
+	// 获取活动最新周期的种子，不存在时返回 nil
+	Latest(ctx context.Context, activityId int64) (*LotterySeed, error)
+	// 获取指定周期的种子，不存在时返回 nil
+	Get(ctx context.Context, activityId, epoch int64) (*LotterySeed, error)
+	List(ctx context.Context, activityId int64) ([]*LotterySeed, error)
+	Create(ctx context.Context, s *LotterySeed) error
+	// 公开种子
+	Reveal(ctx context.Context, activityId, epoch int64) error
+}
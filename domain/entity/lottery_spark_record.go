@@ -0,0 +1,33 @@
+package entity
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	TNLotterySparkRecord = "lottery_spark_record"
+)
+
+// LotterySparkRecord 兑换积分流水，用户积分为流水之和
+type LotterySparkRecord struct {
+	ID        int64     `gorm:"primaryKey;autoIncrement;comment:'积分流水ID'" json:"id"`
+	UserID    int64     `gorm:"not null;index:idx_user_id;comment:'用户ID'" json:"user_id"`
+	Points    int64     `gorm:"not null;comment:'积分变化，兑换和清零为负数'" json:"points"`
+	Type      string    `gorm:"size:16;not null;comment:'变更类型 draw redeem expire'" json:"type"`
+	PrizeID   int64     `gorm:"not null;default:0;comment:'兑换的奖品ID'" json:"prize_id"`
+	RequestID string    `gorm:"size:64;uniqueIndex:uniq_request_id;comment:'请求ID，用于幂等'" json:"request_id"`
+	CreatedAt time.Time `gorm:"not null;comment:'创建时间'" json:"created_at"`
+}
+
+type ILotterySparkRecordRepo interface {
+	CreateTable(ctx context.Context, activityId int64) error
+	TableName(activityId int64) string
+	// 插入积分流水，请求ID重复时不插入并返回 false
+	Add(ctx context.Context, activityId int64, record *LotterySparkRecord) (bool, error)
+	GetByRequestID(ctx context.Context, activityId int64, requestId string) (*LotterySparkRecord, error)
+	// 用户当前积分
+	Sum(ctx context.Context, activityId, userId int64) (int64, error)
+	// 为积分大于0的用户插入清零流水，返回清零的用户数
+	Expire(ctx context.Context, activityId int64) (int64, error)
+}
@@ -0,0 +1,31 @@
+package entity
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	TNLotteryUserState = "lottery_user_state"
+)
+
+// LotteryUserState 用户在活动中的抽奖状态，redis 的持久化备份
+type LotteryUserState struct {
+	ID                int64     `gorm:"primaryKey;autoIncrement;comment:'抽奖状态ID'" json:"id"`
+	ActivityID        int64     `gorm:"not null;comment:'活动ID'" json:"activity_id"`
+	UserID            int64     `gorm:"not null;uniqueIndex:uniq_user_id;comment:'用户ID'" json:"user_id"`
+	Pity              int64     `gorm:"not null;default:0;comment:'保底计数，距上次抽中最高星级的抽数'" json:"pity"`
+	DrawTotal         int64     `gorm:"not null;default:0;comment:'累计抽奖次数'" json:"draw_total"`
+	FeaturedGuarantee bool      `gorm:"not null;default:false;comment:'下次最高星级是否必为UP'" json:"featured_guarantee"`
+	Box               string    `gorm:"type:json;comment:'箱子模式的剩余奖品'" json:"box"`
+	BoxRound          int64     `gorm:"not null;default:0;comment:'箱子轮次，重置后加1'" json:"box_round"`
+	Step              int64     `gorm:"not null;default:0;comment:'阶梯模式已完成的阶梯数'" json:"step"`
+	UpdatedAt         time.Time `gorm:"not null;comment:'更新时间'" json:"updated_at"`
+}
+
+type ILotteryUserStateRepo interface {
+	CreateTable(ctx context.Context, activityId int64) error
+	TableName(activityId int64) string
+	Get(ctx context.Context, activityId, userId int64) (*LotteryUserState, error)
+	Save(ctx context.Context, state *LotteryUserState) error
+}
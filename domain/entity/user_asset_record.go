@@ -13,7 +13,7 @@ type UserAssetRecord struct {
 	Stone       int64     `gorm:"not null;comment:'原石'" json:"stone"`
 	Crystal     int64     `gorm:"not null;comment:'创世结晶'" json:"crystal"`
 	CreatedAt   time.Time `gorm:"not null;comment:'创建时间'" json:"created_at"`
-	RequestID   string    `gorm:"size:36;uniqueIndex:uniq_request_id;comment:'请求ID，用于幂等'" json:"request_id"`
+	RequestID   string    `gorm:"size:64;uniqueIndex:uniq_request_id;comment:'请求ID，用于幂等'" json:"request_id"`
 	RequestTime time.Time `gorm:"not null;comment:'请求时间'" json:"request_time"`
 }
 
@@ -23,6 +23,6 @@ func (u *UserAssetRecord) TableName() string {
 
 type IAssetTransactionRepo interface {
 	//通过requestId查询
-	GetByRequestID(ctx context.Context, requestId string) (*UserAssetRecord, error)
+	GetByRequestID(ctx context.Context, userId int64, requestId string) (*UserAssetRecord, error)
 	//Insert(ctx context.Context, at *AssetTransaction) error //与asset一并插入
 }
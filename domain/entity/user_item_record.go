@@ -1,6 +1,7 @@
 package entity
 
 import (
+	"context"
 	"fmt"
 	"time"
 )
@@ -10,10 +11,15 @@ type UserItemRecord struct {
 	UserID      int64     `gorm:"not null;comment:'用户ID'" json:"user_id"`
 	Items       string    `gorm:"type:json;not null;comment:'变更物品'" json:"items"`
 	CreatedAt   time.Time `gorm:"not null;comment:'创建时间'" json:"created_at"`
-	RequestID   string    `gorm:"size:36;uniqueIndex:uniq_request_id;comment:'请求ID，用于幂等'" json:"request_id"`
+	RequestID   string    `gorm:"size:64;uniqueIndex:uniq_request_id;comment:'请求ID，用于幂等'" json:"request_id"`
 	RequestTime time.Time `gorm:"not null;comment:'请求时间'" json:"request_time"`
 }
 
 func (u *UserItemRecord) TableName() string {
 	return fmt.Sprintf("user_item_record_%d", u.UserID%10)
 }
+
+type IUserItemRecordRepo interface {
+	//通过requestId查询
+	GetByRequestID(ctx context.Context, userId int64, requestId string) (*UserItemRecord, error)
+}
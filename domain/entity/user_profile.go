@@ -0,0 +1,26 @@
+package entity
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	TNUserProfile = "user_profile"
+)
+
+// UserProfile 用户属性，用于选择分群奖池，由用户系统同步
+type UserProfile struct {
+	UserID       int64     `gorm:"primaryKey;comment:'用户ID'" json:"user_id"`
+	RegisterTime time.Time `gorm:"not null;comment:'注册时间'" json:"register_time"`
+	Vip          int64     `gorm:"not null;default:0;comment:'VIP等级'" json:"vip"`
+}
+
+func (u *UserProfile) TableName() string {
+	return TNUserProfile
+}
+
+type IUserProfileRepo interface {
+	// 获取用户属性，不存在时返回 nil
+	Get(ctx context.Context, userId int64) (*UserProfile, error)
+}
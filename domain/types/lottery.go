@@ -7,3 +7,32 @@ const (
 	LotteryStatusDeduct   = 2 //扣除金钱
 	LotteryStatusAward    = 3 //发奖
 )
+
+// 抽奖支付货币，对应用户资产字段
+const (
+	CurrencyGold    = "gold"    // 金币
+	CurrencyStone   = "stone"   // 原石
+	CurrencyCrystal = "crystal" // 创世结晶
+	CurrencyItem    = "item"    // 抽奖券等物品，需配置 item_id
+)
+
+// 活动抽奖模式
+const (
+	ActivityModeBox    = "box"     // 箱子模式，每个用户一箱有限的奖品，不放回抽取
+	ActivityModeStepUp = "step_up" // 阶梯模式，每个阶梯有各自的价格、抽数和奖池，按顺序循环
+)
+
+// 活动状态
+const (
+	ActivityStateScheduled = "scheduled" // 未开始
+	ActivityStateRunning   = "running"   // 进行中
+	ActivityStatePaused    = "paused"    // 暂停
+	ActivityStateEnded     = "ended"     // 已结束
+)
+
+// 兑换积分变更类型
+const (
+	SparkTypeDraw   = "draw"   // 抽奖获得
+	SparkTypeRedeem = "redeem" // 兑换奖品
+	SparkTypeExpire = "expire" // 活动结束清零
+)
@@ -8,9 +8,15 @@ type RepoMysql struct {
 	UserAssetRepo
 	UserAssetRecordRepo
 	UserItemRepo
+	UserItemRecordRepo
+	UserProfileRepo
 
 	LotteryDrawRecordRepo
 	LotteryPrizeRecordRepo
+	LotteryUserStateRepo
+	LotteryPoolVersionRepo
+	LotterySeedRepo
+	LotterySparkRecordRepo
 }
 
 func NewRepoMysql(db *gorm.DB) RepoMysql {
@@ -19,7 +25,13 @@ func NewRepoMysql(db *gorm.DB) RepoMysql {
 	repo.UserAssetRepo = NewUserAssetRepo(db)
 	repo.UserAssetRecordRepo = NewUserAssetRecordRepo(db)
 	repo.UserItemRepo = NewUserItemRepo(db)
+	repo.UserItemRecordRepo = NewUserItemRecordRepo(db)
+	repo.UserProfileRepo = NewUserProfileRepo(db)
 	repo.LotteryDrawRecordRepo = NewLotteryDrawRecordRepo(db)
 	repo.LotteryPrizeRecordRepo = NewLotteryPrizeRecordRepo(db)
+	repo.LotteryUserStateRepo = NewLotteryUserStateRepo(db)
+	repo.LotteryPoolVersionRepo = NewLotteryPoolVersionRepo(db)
+	repo.LotterySeedRepo = NewLotterySeedRepo(db)
+	repo.LotterySparkRecordRepo = NewLotterySparkRecordRepo(db)
 	return *repo
 }
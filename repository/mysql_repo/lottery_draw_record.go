@@ -5,8 +5,8 @@ import (
 	"fmt"
 	"github.com/linchengzhi/lottery/domain/dto"
 	"github.com/linchengzhi/lottery/domain/entity"
+	"github.com/pkg/errors"
 	"gorm.io/gorm"
-	"strings"
 	"time"
 )
 
@@ -21,11 +21,8 @@ func NewLotteryDrawRecordRepo(db *gorm.DB) LotteryDrawRecordRepo {
 // CreateTable 创建奖品记录表 table_name = "lottery_prize_record_" + activityId
 func (r *LotteryDrawRecordRepo) CreateTable(ctx context.Context, activityId int64) error {
 	tableName := r.TableName(activityId)
-	if err := r.db.WithContext(ctx).Table(tableName).Migrator().CreateTable(&entity.LotteryDrawRecord{}); err != nil {
-		//如果表已存在，则忽略错误
-		if strings.Contains(err.Error(), "already exists") {
-			return nil
-		}
+	// 表已存在时补齐新增字段
+	if err := r.db.WithContext(ctx).Table(tableName).AutoMigrate(&entity.LotteryDrawRecord{}); err != nil {
 		return fmt.Errorf("failed to create table %s: %w", tableName, err)
 	}
 	return nil
@@ -64,12 +61,73 @@ func (r *LotteryDrawRecordRepo) Create(ctx context.Context, drawRecord *entity.L
 	return err
 }
 
+// BatchCreate 批量写入抽奖记录和奖品记录，按活动ID分组写入各自的分表
 func (r *LotteryDrawRecordRepo) BatchCreate(ctx context.Context, drawRecords []*entity.LotteryDrawRecord, prizeRecords []*entity.LotteryPrizeRecord) error {
+	drawGroups := make(map[int64][]*entity.LotteryDrawRecord)
+	for _, record := range drawRecords {
+		drawGroups[record.ActivityID] = append(drawGroups[record.ActivityID], record)
+	}
+	prizeGroups := make(map[int64][]*entity.LotteryPrizeRecord)
+	for _, record := range prizeRecords {
+		prizeGroups[record.ActivityID] = append(prizeGroups[record.ActivityID], record)
+	}
 	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		if err := tx.Table(r.TableName(drawRecords[0].ActivityID)).Create(drawRecords).Error; err != nil {
-			return err
+		for activityId, records := range drawGroups {
+			if err := tx.Table(r.TableName(activityId)).Create(records).Error; err != nil {
+				return err
+			}
 		}
 		lp := NewLotteryPrizeRecordRepo(tx)
-		return tx.Table(lp.TableName(drawRecords[0].ActivityID)).Create(prizeRecords).Error
+		for activityId, records := range prizeGroups {
+			if err := tx.Table(lp.TableName(activityId)).Create(records).Error; err != nil {
+				return err
+			}
+		}
+		return nil
 	})
 }
+
+// Stats 统计活动的抽奖请求数、参与用户数和总抽数
+func (r *LotteryDrawRecordRepo) Stats(ctx context.Context, activityId int64) (*entity.LotteryDrawStats, error) {
+	var stats entity.LotteryDrawStats
+	err := r.db.WithContext(ctx).Table(r.TableName(activityId)).
+		Select("COUNT(*) AS records, COUNT(DISTINCT user_id) AS users, COALESCE(SUM(draw_count), 0) AS draws, " +
+			"COALESCE(SUM(IF(free, draw_count, 0)), 0) AS free").
+		Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+func (r *LotteryDrawRecordRepo) VariantStats(ctx context.Context, activityId int64) ([]*entity.LotteryVariantStats, []*entity.LotteryVariantSpend, error) {
+	var stats []*entity.LotteryVariantStats
+	err := r.db.WithContext(ctx).Table(r.TableName(activityId)).
+		Select("variant, COUNT(*) AS records, COUNT(DISTINCT user_id) AS users, COALESCE(SUM(draw_count), 0) AS draws, " +
+			"COALESCE(SUM(IF(free, draw_count, 0)), 0) AS free, COALESCE(SUM(top_num), 0) AS top_num").
+		Group("variant").Order("variant").Scan(&stats).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	var spend []*entity.LotteryVariantSpend
+	err = r.db.WithContext(ctx).Table(r.TableName(activityId)).
+		Select("variant, currency, item_id, COALESCE(SUM(amount), 0) AS amount").
+		Where("currency <> ''").Group("variant, currency, item_id").Scan(&spend).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	return stats, spend, nil
+}
+
+// GetByRequestID 根据请求ID获取抽奖记录，不存在时返回 nil
+func (r *LotteryDrawRecordRepo) GetByRequestID(ctx context.Context, activityId int64, requestId string) (*entity.LotteryDrawRecord, error) {
+	var record entity.LotteryDrawRecord
+	err := r.db.WithContext(ctx).Table(r.TableName(activityId)).Where("request_id = ?", requestId).First(&record).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &record, nil
+}
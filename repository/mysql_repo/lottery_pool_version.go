@@ -0,0 +1,61 @@
+package mysql_repo
+
+import (
+	"context"
+	"github.com/linchengzhi/lottery/domain/entity"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+type LotteryPoolVersionRepo struct {
+	db *gorm.DB
+}
+
+func NewLotteryPoolVersionRepo(db *gorm.DB) LotteryPoolVersionRepo {
+	return LotteryPoolVersionRepo{db: db}
+}
+
+// Latest 获取活动最新的奖池版本，不存在时返回 nil
+func (r *LotteryPoolVersionRepo) Latest(ctx context.Context, activityId int64) (*entity.LotteryPoolVersion, error) {
+	var v entity.LotteryPoolVersion
+	err := r.db.WithContext(ctx).Where("activity_id = ?", activityId).Order("version DESC").First(&v).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &v, nil
+}
+
+// Get 获取活动指定的奖池版本，不存在时返回 nil
+func (r *LotteryPoolVersionRepo) Get(ctx context.Context, activityId, version int64) (*entity.LotteryPoolVersion, error) {
+	var v entity.LotteryPoolVersion
+	err := r.db.WithContext(ctx).Where("activity_id = ? AND version = ?", activityId, version).First(&v).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &v, nil
+}
+
+// GetAt 获取在at时刻生效的版本，即at之前创建的最新版本，不存在时返回 nil
+func (r *LotteryPoolVersionRepo) GetAt(ctx context.Context, activityId int64, at time.Time) (*entity.LotteryPoolVersion, error) {
+	var v entity.LotteryPoolVersion
+	err := r.db.WithContext(ctx).Where("activity_id = ? AND created_at <= ?", activityId, at).Order("version DESC").First(&v).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &v, nil
+}
+
+// Create 插入新版本，(activity_id, version) 唯一，并发创建时只有一个成功
+func (r *LotteryPoolVersionRepo) Create(ctx context.Context, v *entity.LotteryPoolVersion) error {
+	return r.db.WithContext(ctx).Create(v).Error
+}
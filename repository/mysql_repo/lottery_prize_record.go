@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"github.com/linchengzhi/lottery/domain/entity"
 	"gorm.io/gorm"
-	"strings"
 )
 
 type LotteryPrizeRecordRepo struct {
@@ -20,11 +19,8 @@ func NewLotteryPrizeRecordRepo(db *gorm.DB) LotteryPrizeRecordRepo {
 // CreateTable 创建奖品记录表 table_name = "lottery_prize_record_" + activityId
 func (r *LotteryPrizeRecordRepo) CreateTable(ctx context.Context, activityId int64) error {
 	tableName := r.TableName(activityId)
-	if err := r.db.WithContext(ctx).Table(tableName).Migrator().CreateTable(&entity.LotteryPrizeRecord{}); err != nil {
-		//如果表已存在，则忽略错误
-		if strings.Contains(err.Error(), "already exists") {
-			return nil
-		}
+	// 表已存在时补齐新增字段
+	if err := r.db.WithContext(ctx).Table(tableName).AutoMigrate(&entity.LotteryPrizeRecord{}); err != nil {
 		return fmt.Errorf("failed to create table %s: %w", tableName, err)
 	}
 	return nil
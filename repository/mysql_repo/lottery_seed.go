@@ -0,0 +1,65 @@
+package mysql_repo
+
+import (
+	"context"
+	"github.com/linchengzhi/lottery/domain/entity"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+type LotterySeedRepo struct {
+	db *gorm.DB
+}
+
+func NewLotterySeedRepo(db *gorm.DB) LotterySeedRepo {
+	return LotterySeedRepo{db: db}
+}
+
+// Latest 获取活动最新周期的种子，不存在时返回 nil
+func (r *LotterySeedRepo) Latest(ctx context.Context, activityId int64) (*entity.LotterySeed, error) {
+	var s entity.LotterySeed
+	err := r.db.WithContext(ctx).Where("activity_id = ?", activityId).Order("epoch DESC").First(&s).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Get 获取指定周期的种子，不存在时返回 nil
+func (r *LotterySeedRepo) Get(ctx context.Context, activityId, epoch int64) (*entity.LotterySeed, error) {
+	var s entity.LotterySeed
+	err := r.db.WithContext(ctx).Where("activity_id = ? AND epoch = ?", activityId, epoch).First(&s).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &s, nil
+}
+
+// List 按周期倒序获取活动的所有种子
+func (r *LotterySeedRepo) List(ctx context.Context, activityId int64) ([]*entity.LotterySeed, error) {
+	var list []*entity.LotterySeed
+	err := r.db.WithContext(ctx).Where("activity_id = ?", activityId).Order("epoch DESC").Find(&list).Error
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Create 插入新周期的种子，(activity_id, epoch) 唯一，并发创建时只有一个成功
+func (r *LotterySeedRepo) Create(ctx context.Context, s *entity.LotterySeed) error {
+	return r.db.WithContext(ctx).Create(s).Error
+}
+
+// Reveal 公开种子，已公开时不修改公开时间
+func (r *LotterySeedRepo) Reveal(ctx context.Context, activityId, epoch int64) error {
+	return r.db.WithContext(ctx).Model(&entity.LotterySeed{}).
+		Where("activity_id = ? AND epoch = ? AND revealed = ?", activityId, epoch, false).
+		Updates(map[string]interface{}{"revealed": true, "revealed_at": time.Now()}).Error
+}
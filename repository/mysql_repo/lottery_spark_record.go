@@ -0,0 +1,75 @@
+package mysql_repo
+
+import (
+	"context"
+	"fmt"
+	"github.com/linchengzhi/lottery/domain/entity"
+	"github.com/linchengzhi/lottery/domain/types"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+type LotterySparkRecordRepo struct {
+	db *gorm.DB
+}
+
+func NewLotterySparkRecordRepo(db *gorm.DB) LotterySparkRecordRepo {
+	return LotterySparkRecordRepo{db: db}
+}
+
+// CreateTable 创建积分流水表 table_name = "lottery_spark_record_" + activityId，已存在时补齐新增字段
+func (r *LotterySparkRecordRepo) CreateTable(ctx context.Context, activityId int64) error {
+	tableName := r.TableName(activityId)
+	if err := r.db.WithContext(ctx).Table(tableName).AutoMigrate(&entity.LotterySparkRecord{}); err != nil {
+		return fmt.Errorf("failed to create table %s: %w", tableName, err)
+	}
+	return nil
+}
+
+func (r *LotterySparkRecordRepo) TableName(activityId int64) string {
+	return fmt.Sprintf("%s_%d", entity.TNLotterySparkRecord, activityId)
+}
+
+// Add 插入积分流水，请求ID已存在时忽略
+func (r *LotterySparkRecordRepo) Add(ctx context.Context, activityId int64, record *entity.LotterySparkRecord) (bool, error) {
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now()
+	}
+	result := r.db.WithContext(ctx).Table(r.TableName(activityId)).Clauses(clause.Insert{Modifier: "IGNORE"}).Create(record)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// GetByRequestID 根据请求ID查询积分流水，不存在时返回 nil
+func (r *LotterySparkRecordRepo) GetByRequestID(ctx context.Context, activityId int64, requestId string) (*entity.LotterySparkRecord, error) {
+	var record entity.LotterySparkRecord
+	err := r.db.WithContext(ctx).Table(r.TableName(activityId)).Where("request_id = ?", requestId).First(&record).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (r *LotterySparkRecordRepo) Sum(ctx context.Context, activityId, userId int64) (int64, error) {
+	var sum int64
+	err := r.db.WithContext(ctx).Table(r.TableName(activityId)).Where("user_id = ?", userId).
+		Select("COALESCE(SUM(points), 0)").Scan(&sum).Error
+	return sum, err
+}
+
+// Expire 清零流水的请求ID为 expire:用户ID，重复执行不会重复清零
+func (r *LotterySparkRecordRepo) Expire(ctx context.Context, activityId int64) (int64, error) {
+	tableName := r.TableName(activityId)
+	sql := fmt.Sprintf("INSERT IGNORE INTO %s (user_id, points, type, prize_id, request_id, created_at) "+
+		"SELECT user_id, -SUM(points), ?, 0, CONCAT('expire:', user_id), ? FROM %s GROUP BY user_id HAVING SUM(points) > 0",
+		tableName, tableName)
+	result := r.db.WithContext(ctx).Exec(sql, types.SparkTypeExpire, time.Now())
+	return result.RowsAffected, result.Error
+}
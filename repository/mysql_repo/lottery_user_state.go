@@ -0,0 +1,66 @@
+package mysql_repo
+
+import (
+	"context"
+	"fmt"
+	"github.com/linchengzhi/lottery/domain/entity"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+type LotteryUserStateRepo struct {
+	db *gorm.DB
+}
+
+func NewLotteryUserStateRepo(db *gorm.DB) LotteryUserStateRepo {
+	return LotteryUserStateRepo{db: db}
+}
+
+// CreateTable 创建抽奖状态表 table_name = "lottery_user_state_" + activityId，已存在时补齐新增字段
+func (r *LotteryUserStateRepo) CreateTable(ctx context.Context, activityId int64) error {
+	tableName := r.TableName(activityId)
+	if err := r.db.WithContext(ctx).Table(tableName).AutoMigrate(&entity.LotteryUserState{}); err != nil {
+		return fmt.Errorf("failed to create table %s: %w", tableName, err)
+	}
+	return nil
+}
+
+func (r *LotteryUserStateRepo) TableName(activityId int64) string {
+	tableName := fmt.Sprintf("%s_%d", entity.TNLotteryUserState, activityId)
+	return tableName
+}
+
+// Get 获取用户抽奖状态，不存在时返回 nil
+func (r *LotteryUserStateRepo) Get(ctx context.Context, activityId, userId int64) (*entity.LotteryUserState, error) {
+	var state entity.LotteryUserState
+	err := r.db.WithContext(ctx).Table(r.TableName(activityId)).Where("user_id = ?", userId).First(&state).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &state, nil
+}
+
+// Save 保存用户抽奖状态，发奖是并发的，只有累计抽数更大的状态才会覆盖旧数据
+// 重置箱子不增加抽数，箱子轮次更大时也覆盖箱子状态
+func (r *LotteryUserStateRepo) Save(ctx context.Context, state *entity.LotteryUserState) error {
+	state.UpdatedAt = time.Now()
+	// 按顺序赋值，draw_total 和 box_round 必须最后更新，否则前面的比较会用到新值
+	newer := "VALUES(draw_total) > draw_total"
+	boxNewer := "(VALUES(box_round) > box_round OR (VALUES(box_round) = box_round AND " + newer + "))"
+	return r.db.WithContext(ctx).Table(r.TableName(state.ActivityID)).Clauses(clause.OnConflict{
+		DoUpdates: clause.Set{
+			{Column: clause.Column{Name: "pity"}, Value: gorm.Expr("IF(" + newer + ", VALUES(pity), pity)")},
+			{Column: clause.Column{Name: "featured_guarantee"}, Value: gorm.Expr("IF(" + newer + ", VALUES(featured_guarantee), featured_guarantee)")},
+			{Column: clause.Column{Name: "step"}, Value: gorm.Expr("IF(" + newer + ", VALUES(step), step)")},
+			{Column: clause.Column{Name: "box"}, Value: gorm.Expr("IF(" + boxNewer + ", VALUES(box), box)")},
+			{Column: clause.Column{Name: "updated_at"}, Value: gorm.Expr("IF(" + newer + " OR " + boxNewer + ", VALUES(updated_at), updated_at)")},
+			{Column: clause.Column{Name: "box_round"}, Value: gorm.Expr("GREATEST(box_round, VALUES(box_round))")},
+			{Column: clause.Column{Name: "draw_total"}, Value: gorm.Expr("GREATEST(draw_total, VALUES(draw_total))")},
+		},
+	}).Create(state).Error
+}
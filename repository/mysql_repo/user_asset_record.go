@@ -3,6 +3,7 @@ package mysql_repo
 import (
 	"context"
 	"github.com/linchengzhi/lottery/domain/entity"
+	"github.com/pkg/errors"
 	"gorm.io/gorm"
 )
 
@@ -16,10 +17,15 @@ func NewUserAssetRecordRepo(db *gorm.DB) UserAssetRecordRepo {
 	}
 }
 
-func (u *UserAssetRecordRepo) GetByRequestID(ctx context.Context, requestId string) (*entity.UserAssetRecord, error) {
+// GetByRequestID 根据 request_id 查询资产变更记录，记录按用户分表，不存在时返回 nil
+func (u *UserAssetRecordRepo) GetByRequestID(ctx context.Context, userId int64, requestId string) (*entity.UserAssetRecord, error) {
 	var record entity.UserAssetRecord
-	err := u.db.WithContext(ctx).Where("request_id = ?", requestId).First(&record).Error
+	tableName := (&entity.UserAssetRecord{UserID: userId}).TableName()
+	err := u.db.WithContext(ctx).Table(tableName).Where("request_id = ?", requestId).First(&record).Error
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
 		return nil, err
 	}
 	return &record, nil
@@ -6,6 +6,7 @@ import (
 	"github.com/linchengzhi/lottery/domain/cerror"
 	"github.com/linchengzhi/lottery/domain/entity"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"time"
 )
 
@@ -53,8 +54,8 @@ func (r *UserItemRepo) Update(ctx context.Context, userId int64, items map[int64
 			itemIDs = append(itemIDs, itemId)
 		}
 		tableName := (&entity.UserItem{UserID: userId}).TableName()
-		// 查询所有相关物品
-		if err := tx.Table(tableName).Where("user_id = ? AND item_id IN ?", userId, itemIDs).Find(&userItems).Error; err != nil {
+		// 查询并锁定所有相关物品，同一用户的物品变更串行执行，避免并发扣除或发放时丢失更新
+		if err := tx.Table(tableName).Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ? AND item_id IN ?", userId, itemIDs).Find(&userItems).Error; err != nil {
 			return err
 		}
 
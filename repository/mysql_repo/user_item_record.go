@@ -0,0 +1,32 @@
+package mysql_repo
+
+import (
+	"context"
+	"github.com/linchengzhi/lottery/domain/entity"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+type UserItemRecordRepo struct {
+	db *gorm.DB
+}
+
+func NewUserItemRecordRepo(db *gorm.DB) UserItemRecordRepo {
+	return UserItemRecordRepo{
+		db: db,
+	}
+}
+
+// GetByRequestID 根据 request_id 查询物品变更记录，记录按用户分表，不存在时返回 nil
+func (u *UserItemRecordRepo) GetByRequestID(ctx context.Context, userId int64, requestId string) (*entity.UserItemRecord, error) {
+	var record entity.UserItemRecord
+	tableName := (&entity.UserItemRecord{UserID: userId}).TableName()
+	err := u.db.WithContext(ctx).Table(tableName).Where("request_id = ?", requestId).First(&record).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &record, nil
+}
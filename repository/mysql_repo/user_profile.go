@@ -0,0 +1,29 @@
+package mysql_repo
+
+import (
+	"context"
+	"github.com/linchengzhi/lottery/domain/entity"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+type UserProfileRepo struct {
+	db *gorm.DB
+}
+
+func NewUserProfileRepo(db *gorm.DB) UserProfileRepo {
+	return UserProfileRepo{db: db}
+}
+
+// Get 获取用户属性，不存在时返回 nil
+func (r *UserProfileRepo) Get(ctx context.Context, userId int64) (*entity.UserProfile, error) {
+	var p entity.UserProfile
+	err := r.db.WithContext(ctx).Where("user_id = ?", userId).First(&p).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &p, nil
+}
@@ -12,6 +12,12 @@ type RepoRedis struct {
 	UserAssetCache
 	UserItemCache
 	LotteryRecordCache
+	LotteryStateCache
+	LotterySeedCache
+	LotteryLimitRd
+	LotteryFreeRd
+	LotterySparkCache
+	PrizePoolRd
 }
 
 type RepoStream struct {
@@ -24,6 +30,12 @@ func NewRepoRedis(rd *redis.Client) RepoRedis {
 	repo.UserAssetCache = NewUserAssetCache(rd)
 	repo.UserItemCache = NewUserItemCache(rd)
 	repo.LotteryRecordCache = NewLotteryRecordCache(rd)
+	repo.LotteryStateCache = NewLotteryStateCache(rd)
+	repo.LotterySeedCache = NewLotterySeedCache(rd)
+	repo.LotteryLimitRd = NewLotteryLimitRd(rd)
+	repo.LotteryFreeRd = NewLotteryFreeRd(rd)
+	repo.LotterySparkCache = NewLotterySparkCache(rd)
+	repo.PrizePoolRd = NewPrizePoolRd(rd)
 	return *repo
 }
 
@@ -0,0 +1,44 @@
+package redis_repo
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+// 用户免费抽奖次数
+type ILotteryFreeRd interface {
+	// 原子占用一个周期的免费抽奖，已被占用时返回 false，ttl为周期的保存时间
+	Take(ctx context.Context, activityId, userId int64, period, requestId string, ttl time.Duration) (bool, error)
+	// 撤销占用，只有该请求占用的免费抽奖才会撤销
+	Undo(ctx context.Context, activityId, userId int64, period, requestId string) error
+}
+
+const keyLotteryFree = "lottery:free:%d:%d:%s" // 免费抽奖 活动id-用户id-周期，值为占用的请求id
+
+// KEYS: 免费抽奖; ARGV: 请求id
+var undoFreeScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+type LotteryFreeRd struct {
+	rd *redis.Client
+}
+
+func NewLotteryFreeRd(rd *redis.Client) LotteryFreeRd {
+	return LotteryFreeRd{rd: rd}
+}
+
+func (r *LotteryFreeRd) Take(ctx context.Context, activityId, userId int64, period, requestId string, ttl time.Duration) (bool, error) {
+	key := fmt.Sprintf(keyLotteryFree, activityId, userId, period)
+	return r.rd.SetNX(ctx, key, requestId, ttl).Result()
+}
+
+func (r *LotteryFreeRd) Undo(ctx context.Context, activityId, userId int64, period, requestId string) error {
+	keys := []string{fmt.Sprintf(keyLotteryFree, activityId, userId, period)}
+	return undoFreeScript.Run(ctx, r.rd, keys, requestId).Err()
+}
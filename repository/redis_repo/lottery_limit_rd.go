@@ -0,0 +1,92 @@
+package redis_repo
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+// 用户抽奖次数限制
+type ILotteryLimitRd interface {
+	// 原子检查并增加每日和活动累计抽数，超出限制时不增加，max为0表示不限制
+	Take(ctx context.Context, activityId, userId int64, day string, num int64, limit LimitArgs) (int64, error)
+	// 撤销增加的抽数，同一请求只撤销一次
+	Undo(ctx context.Context, activityId, userId int64, day string, num int64, requestId string) error
+}
+
+// 抽数限制参数
+type LimitArgs struct {
+	Daily      int64         // 每日最多抽数
+	Total      int64         // 活动累计最多抽数
+	TotalFloor int64         // 已完成的累计抽数，累计计数丢失时以此为准
+	TotalTTL   time.Duration // 累计计数的保存时间
+}
+
+const (
+	LimitOk    = int64(0)
+	LimitDaily = int64(1) // 超出每日限制
+	LimitTotal = int64(2) // 超出活动累计限制
+)
+
+var (
+	keyLimitDaily = "lottery:limit:daily:%d:%d:%s" // 每日抽数 活动id-用户id-日期
+	keyLimitTotal = "lottery:limit:total:%d:%d"    // 活动累计抽数 活动id-用户id
+	keyLimitUndo  = "lottery:limit:undo:%s"        // 已撤销的请求 请求id
+)
+
+// KEYS: 每日计数 累计计数; ARGV: 抽数 每日上限 累计上限 累计下限 累计过期秒数
+var takeLimitScript = redis.NewScript(`
+local n = tonumber(ARGV[1])
+local daily = tonumber(redis.call('GET', KEYS[1]) or '0')
+local total = math.max(tonumber(redis.call('GET', KEYS[2]) or '0'), tonumber(ARGV[4]))
+if tonumber(ARGV[2]) > 0 and daily + n > tonumber(ARGV[2]) then
+	return 1
+end
+if tonumber(ARGV[3]) > 0 and total + n > tonumber(ARGV[3]) then
+	return 2
+end
+redis.call('SET', KEYS[1], daily + n, 'EX', 172800)
+redis.call('SET', KEYS[2], total + n, 'EX', tonumber(ARGV[5]))
+return 0
+`)
+
+// KEYS: 每日计数 累计计数 撤销标记; ARGV: 抽数
+var undoLimitScript = redis.NewScript(`
+if not redis.call('SET', KEYS[3], 1, 'NX', 'EX', 86400) then
+	return 0
+end
+local n = tonumber(ARGV[1])
+for i = 1, 2 do
+	local left = tonumber(redis.call('GET', KEYS[i]) or '0')
+	if left > 0 then
+		redis.call('DECRBY', KEYS[i], math.min(left, n))
+	end
+end
+return 1
+`)
+
+type LotteryLimitRd struct {
+	rd *redis.Client
+}
+
+func NewLotteryLimitRd(rd *redis.Client) LotteryLimitRd {
+	return LotteryLimitRd{rd: rd}
+}
+
+func (r *LotteryLimitRd) Take(ctx context.Context, activityId, userId int64, day string, num int64, limit LimitArgs) (int64, error) {
+	keys := []string{
+		fmt.Sprintf(keyLimitDaily, activityId, userId, day),
+		fmt.Sprintf(keyLimitTotal, activityId, userId),
+	}
+	return takeLimitScript.Run(ctx, r.rd, keys, num, limit.Daily, limit.Total, limit.TotalFloor, int64(limit.TotalTTL/time.Second)).Int64()
+}
+
+func (r *LotteryLimitRd) Undo(ctx context.Context, activityId, userId int64, day string, num int64, requestId string) error {
+	keys := []string{
+		fmt.Sprintf(keyLimitDaily, activityId, userId, day),
+		fmt.Sprintf(keyLimitTotal, activityId, userId),
+		fmt.Sprintf(keyLimitUndo, requestId),
+	}
+	return undoLimitScript.Run(ctx, r.rd, keys, num).Err()
+}
@@ -0,0 +1,63 @@
+package redis_repo
+
+import (
+	"context"
+	"fmt"
+	"github.com/bytedance/sonic"
+	"github.com/go-redis/redis/v8"
+	"github.com/linchengzhi/lottery/domain/entity"
+	"time"
+)
+
+type ILotterySeedRd interface {
+	// 获取活动当前使用的种子，不存在时返回 nil
+	Get(ctx context.Context, activityId int64) (*entity.LotterySeed, error)
+	Set(ctx context.Context, seed *entity.LotterySeed) error
+	Del(ctx context.Context, activityId int64) error
+}
+
+type LotterySeedCache struct {
+	rdb        *redis.Client
+	expiration time.Duration
+}
+
+const (
+	keyLotterySeed = "lottery:seed:%d" // 活动当前种子 活动id
+)
+
+func NewLotterySeedCache(rdb *redis.Client) LotterySeedCache {
+	return LotterySeedCache{
+		rdb:        rdb,
+		expiration: time.Duration(24) * time.Hour, // 过期后从mysql恢复
+	}
+}
+
+func (r *LotterySeedCache) Get(ctx context.Context, activityId int64) (*entity.LotterySeed, error) {
+	key := fmt.Sprintf(keyLotterySeed, activityId)
+	data, err := r.rdb.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return nil, nil // 缓存未命中
+	} else if err != nil {
+		return nil, err
+	}
+
+	var seed = new(entity.LotterySeed)
+	if err = sonic.Unmarshal([]byte(data), seed); err != nil {
+		return nil, err
+	}
+	return seed, nil
+}
+
+func (r *LotterySeedCache) Set(ctx context.Context, seed *entity.LotterySeed) error {
+	key := fmt.Sprintf(keyLotterySeed, seed.ActivityID)
+	data, err := sonic.Marshal(seed)
+	if err != nil {
+		return err
+	}
+	return r.rdb.Set(ctx, key, data, r.expiration).Err()
+}
+
+func (r *LotterySeedCache) Del(ctx context.Context, activityId int64) error {
+	key := fmt.Sprintf(keyLotterySeed, activityId)
+	return r.rdb.Del(ctx, key).Err()
+}
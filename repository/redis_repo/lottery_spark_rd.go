@@ -0,0 +1,48 @@
+package redis_repo
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+// 用户兑换积分缓存，以mysql流水为准，流水变化时删除
+type ILotterySparkCache interface {
+	// 获取用户积分，缓存未命中时返回 false
+	Get(ctx context.Context, activityId, userId int64) (int64, bool, error)
+	Set(ctx context.Context, activityId, userId int64, points int64) error
+	Del(ctx context.Context, activityId, userId int64) error
+}
+
+const keyLotterySpark = "lottery:spark:%d:%d" // 用户兑换积分 活动id-用户id
+
+type LotterySparkCache struct {
+	rdb        *redis.Client
+	expiration time.Duration
+}
+
+func NewLotterySparkCache(rdb *redis.Client) LotterySparkCache {
+	return LotterySparkCache{
+		rdb:        rdb,
+		expiration: time.Duration(1) * time.Hour,
+	}
+}
+
+func (r *LotterySparkCache) Get(ctx context.Context, activityId, userId int64) (int64, bool, error) {
+	points, err := r.rdb.Get(ctx, fmt.Sprintf(keyLotterySpark, activityId, userId)).Int64()
+	if err == redis.Nil {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return points, true, nil
+}
+
+func (r *LotterySparkCache) Set(ctx context.Context, activityId, userId int64, points int64) error {
+	return r.rdb.Set(ctx, fmt.Sprintf(keyLotterySpark, activityId, userId), points, r.expiration).Err()
+}
+
+func (r *LotterySparkCache) Del(ctx context.Context, activityId, userId int64) error {
+	return r.rdb.Del(ctx, fmt.Sprintf(keyLotterySpark, activityId, userId)).Err()
+}
@@ -0,0 +1,106 @@
+package redis_repo
+
+import (
+	"context"
+	"fmt"
+	"github.com/bytedance/sonic"
+	"github.com/go-redis/redis/v8"
+	"github.com/linchengzhi/lottery/domain/dto"
+	"github.com/linchengzhi/lottery/util"
+	"time"
+)
+
+type ILotteryStateRd interface {
+	Get(ctx context.Context, activityId, userId int64) (*dto.DrawState, error)
+	Set(ctx context.Context, activityId, userId int64, state *dto.DrawState) error
+	// 用户抽奖锁，同一用户同一活动的抽奖串行执行，加锁成功时返回锁的token
+	Lock(ctx context.Context, activityId, userId int64) (string, bool, error)
+	// 释放锁，只有持有token的锁才会释放，避免超时后释放他人的锁
+	Unlock(ctx context.Context, activityId, userId int64, token string) error
+	// 用户发奖锁，同一用户的唯一奖品判断和发放串行执行，与活动无关
+	LockAward(ctx context.Context, userId int64) (string, bool, error)
+	UnlockAward(ctx context.Context, userId int64, token string) error
+}
+
+type LotteryStateCache struct {
+	rdb         *redis.Client
+	expiration  time.Duration
+	lockTimeout time.Duration
+}
+
+const (
+	keyLotteryState = "lottery:state:%d:%d"   // 用户抽奖状态 活动id-用户id
+	keyLotteryLock  = "lottery:lock:%d:%d"    // 用户抽奖锁 活动id-用户id
+	keyAwardLock    = "lottery:award_lock:%d" // 用户发奖锁 用户id
+)
+
+// KEYS: 用户抽奖锁; ARGV: 锁的token
+var unlockStateScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+func NewLotteryStateCache(rdb *redis.Client) LotteryStateCache {
+	return LotteryStateCache{
+		rdb:         rdb,
+		expiration:  time.Duration(7*24) * time.Hour, // 7天过期，过期后从mysql恢复
+		lockTimeout: time.Duration(30) * time.Second, // 与抽奖请求超时一致
+	}
+}
+
+func (r *LotteryStateCache) Get(ctx context.Context, activityId, userId int64) (*dto.DrawState, error) {
+	key := fmt.Sprintf(keyLotteryState, activityId, userId)
+	data, err := r.rdb.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return nil, nil // 缓存未命中
+	} else if err != nil {
+		return nil, err
+	}
+
+	var state = new(dto.DrawState)
+	if err = sonic.Unmarshal([]byte(data), state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (r *LotteryStateCache) Set(ctx context.Context, activityId, userId int64, state *dto.DrawState) error {
+	key := fmt.Sprintf(keyLotteryState, activityId, userId)
+	data, err := sonic.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return r.rdb.Set(ctx, key, data, r.expiration).Err()
+}
+
+func (r *LotteryStateCache) Lock(ctx context.Context, activityId, userId int64) (string, bool, error) {
+	key := fmt.Sprintf(keyLotteryLock, activityId, userId)
+	token := util.UUID()
+	ok, err := r.rdb.SetNX(ctx, key, token, r.lockTimeout).Result()
+	if err != nil || !ok {
+		return "", false, err
+	}
+	return token, true, nil
+}
+
+func (r *LotteryStateCache) Unlock(ctx context.Context, activityId, userId int64, token string) error {
+	keys := []string{fmt.Sprintf(keyLotteryLock, activityId, userId)}
+	return unlockStateScript.Run(ctx, r.rdb, keys, token).Err()
+}
+
+func (r *LotteryStateCache) LockAward(ctx context.Context, userId int64) (string, bool, error) {
+	key := fmt.Sprintf(keyAwardLock, userId)
+	token := util.UUID()
+	ok, err := r.rdb.SetNX(ctx, key, token, r.lockTimeout).Result()
+	if err != nil || !ok {
+		return "", false, err
+	}
+	return token, true, nil
+}
+
+func (r *LotteryStateCache) UnlockAward(ctx context.Context, userId int64, token string) error {
+	keys := []string{fmt.Sprintf(keyAwardLock, userId)}
+	return unlockStateScript.Run(ctx, r.rdb, keys, token).Err()
+}
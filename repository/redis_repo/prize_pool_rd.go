@@ -11,18 +11,69 @@ import (
 // 使用hash保存奖池
 type IPrizePoolRd interface {
 	Get(ctx context.Context, activityId int64) (map[int64]int64, error)
-	DecrBy(ctx context.Context, activityId int64, data map[int64]int64) error
-	Set(ctx context.Context, activityId int64, data map[int64]int64, ttl time.Duration) error
+	// 原子扣减库存，库存不足时只扣减剩余部分，返回实际扣减的数量
+	DecrBy(ctx context.Context, activityId int64, data map[int64]int64) (map[int64]int64, error)
+	// 归还库存
+	IncrBy(ctx context.Context, activityId int64, data map[int64]int64) error
+	// 同步配置的库存，首次同步时写入配置库存，之后按配置的变化量调整剩余库存，expireAt为零表示不过期
+	Sync(ctx context.Context, activityId int64, data map[int64]int64, expireAt time.Time) error
 }
 
-var keyPrizePoolHash = "lottery:" + "prize_pool:%d"
+var (
+	keyPrizePoolHash  = "lottery:" + "prize_pool:%d"       // 剩余库存
+	keyPrizePoolTotal = "lottery:" + "prize_pool_total:%d" // 已同步的配置库存
+)
+
+// 按 field-num 成对传参，逐个扣减 min(库存, num)，返回 field-实际扣减数量
+var decrStockScript = redis.NewScript(`
+local result = {}
+for i = 1, #ARGV, 2 do
+	local left = tonumber(redis.call('HGET', KEYS[1], ARGV[i]) or '0')
+	local take = math.min(left, tonumber(ARGV[i + 1]))
+	if take > 0 then
+		redis.call('HINCRBY', KEYS[1], ARGV[i], -take)
+	else
+		take = 0
+	end
+	table.insert(result, ARGV[i])
+	table.insert(result, take)
+end
+return result
+`)
+
+// KEYS: 剩余库存 配置库存; ARGV: 过期时间戳(0为不过期) 之后按 field-配置库存 成对传参
+// 配置库存增加或减少时剩余库存同步增减，减少到已发出的数量以下时剩余库存为负，不再发放
+var syncStockScript = redis.NewScript(`
+for i = 2, #ARGV, 2 do
+	local total = tonumber(ARGV[i + 1])
+	local old = redis.call('HGET', KEYS[2], ARGV[i])
+	if old then
+		local diff = total - tonumber(old)
+		if diff ~= 0 then
+			redis.call('HINCRBY', KEYS[1], ARGV[i], diff)
+		end
+	else
+		redis.call('HSETNX', KEYS[1], ARGV[i], total)
+	end
+	redis.call('HSET', KEYS[2], ARGV[i], total)
+end
+local at = tonumber(ARGV[1])
+for i = 1, 2 do
+	if at > 0 then
+		redis.call('EXPIREAT', KEYS[i], at)
+	else
+		redis.call('PERSIST', KEYS[i])
+	end
+end
+return 1
+`)
 
 type PrizePoolRd struct {
 	rd *redis.Client
 }
 
-func NewPrizePoolRd(rd *redis.Client) *PrizePoolRd {
-	return &PrizePoolRd{rd: rd}
+func NewPrizePoolRd(rd *redis.Client) PrizePoolRd {
+	return PrizePoolRd{rd: rd}
 }
 
 func (r *PrizePoolRd) Get(ctx context.Context, activityId int64) (map[int64]int64, error) {
@@ -40,45 +91,48 @@ func (r *PrizePoolRd) Get(ctx context.Context, activityId int64) (map[int64]int6
 	return result, nil
 }
 
-func (r *PrizePoolRd) DecrBy(ctx context.Context, activityId int64, data map[int64]int64) error {
+func (r *PrizePoolRd) DecrBy(ctx context.Context, activityId int64, data map[int64]int64) (map[int64]int64, error) {
 	key := fmt.Sprintf(keyPrizePoolHash, activityId)
-	pipe := r.rd.Pipeline()
+	args := make([]interface{}, 0, len(data)*2)
 	for k, v := range data {
-		field := fmt.Sprintf("%d", k)
-		pipe.HIncrBy(ctx, key, field, -v)
+		args = append(args, fmt.Sprintf("%d", k), v)
 	}
-	_, err := pipe.Exec(ctx)
+	values, err := decrStockScript.Run(ctx, r.rd, []string{key}, args...).Slice()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	result := make(map[int64]int64)
+	for i := 0; i+1 < len(values); i += 2 {
+		id, _ := strconv.ParseInt(values[i].(string), 10, 64)
+		result[id] = values[i+1].(int64)
+	}
+	return result, nil
 }
 
-func (r *PrizePoolRd) Set(ctx context.Context, activityId int64, data map[int64]int64, ttl time.Duration) error {
+func (r *PrizePoolRd) IncrBy(ctx context.Context, activityId int64, data map[int64]int64) error {
 	key := fmt.Sprintf(keyPrizePoolHash, activityId)
-	// 检查键是否存在
-	exists, err := r.rd.Exists(ctx, key).Result()
-	if err != nil {
-		return err
-	}
-	if exists > 0 {
-		return nil
-	}
-
-	// 构造要写入的数据
 	pipe := r.rd.Pipeline()
 	for k, v := range data {
 		field := fmt.Sprintf("%d", k)
-		pipe.HSet(ctx, key, field, v)
+		pipe.HIncrBy(ctx, key, field, v)
 	}
-	_, err = pipe.Exec(ctx)
+	_, err := pipe.Exec(ctx)
 	if err != nil {
 		return err
 	}
+	return nil
+}
 
-	_, err = r.rd.Expire(ctx, key, ttl).Result()
-	if err != nil {
-		return err
+func (r *PrizePoolRd) Sync(ctx context.Context, activityId int64, data map[int64]int64, expireAt time.Time) error {
+	keys := []string{fmt.Sprintf(keyPrizePoolHash, activityId), fmt.Sprintf(keyPrizePoolTotal, activityId)}
+	var at int64
+	if !expireAt.IsZero() {
+		at = expireAt.Unix()
 	}
-	return nil
+	args := make([]interface{}, 0, len(data)*2+1)
+	args = append(args, at)
+	for k, v := range data {
+		args = append(args, fmt.Sprintf("%d", k), v)
+	}
+	return syncStockScript.Run(ctx, r.rd, keys, args...).Err()
 }
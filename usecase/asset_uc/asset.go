@@ -2,6 +2,7 @@ package asset_uc
 
 import (
 	"context"
+	"github.com/bytedance/sonic"
 	"github.com/linchengzhi/lottery/domain/entity"
 	"github.com/linchengzhi/lottery/repository/mysql_repo"
 	"github.com/linchengzhi/lottery/repository/redis_repo"
@@ -15,7 +16,9 @@ type IAssetUc interface {
 	// 获取资产
 	GetAsset(ctx context.Context, userID int64) (*entity.UserAsset, error)
 	// 获取资产记录
-	GetAssetRecord(ctx context.Context, requestId string) (*entity.UserAssetRecord, error)
+	GetAssetRecord(ctx context.Context, userId int64, requestId string) (*entity.UserAssetRecord, error)
+	// 获取物品记录
+	GetItemRecord(ctx context.Context, userId int64, requestId string) (map[int64]int64, error)
 	// 获取物品
 	ListItem(ctx context.Context, userID int64) (map[int64]int64, error)
 	// 更新资产
@@ -33,15 +36,18 @@ type AssetUc struct {
 	assetRepo   mysql_repo.UserAssetRepo
 	assetRecord mysql_repo.UserAssetRecordRepo
 	itemRepo    mysql_repo.UserItemRepo
+	itemRecord  mysql_repo.UserItemRecordRepo
 }
 
 func NewAssetUc(log *zap.Logger, repoMysql mysql_repo.RepoMysql, repoRedis redis_repo.RepoRedis) AssetUc {
 	return AssetUc{
-		log:        log,
-		assetCache: repoRedis.UserAssetCache,
-		itemCache:  repoRedis.UserItemCache,
-		assetRepo:  repoMysql.UserAssetRepo,
-		itemRepo:   repoMysql.UserItemRepo,
+		log:         log,
+		assetCache:  repoRedis.UserAssetCache,
+		itemCache:   repoRedis.UserItemCache,
+		assetRepo:   repoMysql.UserAssetRepo,
+		assetRecord: repoMysql.UserAssetRecordRepo,
+		itemRepo:    repoMysql.UserItemRepo,
+		itemRecord:  repoMysql.UserItemRecordRepo,
 	}
 }
 
@@ -84,8 +90,21 @@ func (uc *AssetUc) GetAsset(ctx context.Context, userID int64) (*entity.UserAsse
 	return asset, nil
 }
 
-func (uc *AssetUc) GetAssetRecord(ctx context.Context, requestId string) (*entity.UserAssetRecord, error) {
-	return uc.assetRecord.GetByRequestID(ctx, requestId)
+func (uc *AssetUc) GetAssetRecord(ctx context.Context, userId int64, requestId string) (*entity.UserAssetRecord, error) {
+	return uc.assetRecord.GetByRequestID(ctx, userId, requestId)
+}
+
+// 获取物品变更记录中的物品数量，记录不存在时返回 nil
+func (uc *AssetUc) GetItemRecord(ctx context.Context, userId int64, requestId string) (map[int64]int64, error) {
+	record, err := uc.itemRecord.GetByRequestID(ctx, userId, requestId)
+	if err != nil || record == nil {
+		return nil, err
+	}
+	items := make(map[int64]int64)
+	if err = sonic.UnmarshalString(record.Items, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
 func (uc *AssetUc) ListItem(ctx context.Context, userID int64) (map[int64]int64, error) {
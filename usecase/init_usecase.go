@@ -14,9 +14,9 @@ type UcAll struct {
 	lottery_uc.LotteryUc
 }
 
-func NewUcAll(log *zap.Logger, g *gpool.Pool, repoMysql mysql_repo.RepoMysql, repoRedis redis_repo.RepoRedis, repoStream redis_repo.RepoStream) UcAll {
+func NewUcAll(log *zap.Logger, g *gpool.Pool, repoMysql mysql_repo.RepoMysql, repoRedis redis_repo.RepoRedis, repoStream redis_repo.RepoStream, rng lottery_uc.Rng, userAttr lottery_uc.UserAttrProvider) UcAll {
 	uc := new(UcAll)
 	uc.AssetUc = asset_uc.NewAssetUc(log, repoMysql, repoRedis)
-	uc.LotteryUc = lottery_uc.NewLotteryUc(log, g, repoMysql, repoRedis, repoStream, uc.AssetUc)
+	uc.LotteryUc = lottery_uc.NewLotteryUc(log, g, repoMysql, repoRedis, repoStream, uc.AssetUc, rng, userAttr)
 	return *uc
 }
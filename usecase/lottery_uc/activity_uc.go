@@ -0,0 +1,134 @@
+package lottery_uc
+
+import (
+	"context"
+	"github.com/linchengzhi/lottery/domain/types"
+	"github.com/linchengzhi/lottery/util"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// ActivityHook 活动开始或结束时执行的钩子
+type ActivityHook func(ctx context.Context, puc IPrizePoolUc) error
+
+type activityHooks struct {
+	mu    sync.RWMutex
+	start []ActivityHook
+	end   []ActivityHook
+}
+
+// AddStartHook 注册活动开始时执行的钩子
+func (uc *LotteryUc) AddStartHook(hook ActivityHook) {
+	uc.hooks.mu.Lock()
+	defer uc.hooks.mu.Unlock()
+	uc.hooks.start = append(uc.hooks.start, hook)
+}
+
+// AddEndHook 注册活动结束时执行的钩子
+func (uc *LotteryUc) AddEndHook(hook ActivityHook) {
+	uc.hooks.mu.Lock()
+	defer uc.hooks.mu.Unlock()
+	uc.hooks.end = append(uc.hooks.end, hook)
+}
+
+// listPrizePool 获取当前所有活动奖池
+func (uc *LotteryUc) listPrizePool() []IPrizePoolUc {
+	uc.prizeMu.RLock()
+	defer uc.prizeMu.RUnlock()
+	list := make([]IPrizePoolUc, 0, len(uc.prizePool))
+	for _, p := range uc.prizePool {
+		list = append(list, p)
+	}
+	return list
+}
+
+// processActivityState 定时检查活动状态
+// 未开始(或服务启动时)变为进行中时执行开始钩子，变为结束(或服务启动、加载时已结束)时执行结束钩子，暂停恢复不执行
+// 重启后已结束的活动会再次执行结束钩子，结束钩子需要可重复执行
+func (uc *LotteryUc) processActivityState(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(1) * time.Second)
+	defer ticker.Stop()
+
+	states := make(map[int64]string) // 活动id->上次检查时的状态
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, puc := range uc.listPrizePool() {
+				activityId := puc.getActivityId(ctx)
+				state := puc.getState(ctx, now)
+				last, ok := states[activityId]
+				states[activityId] = state
+				if ok && last == state {
+					continue
+				}
+				uc.log.Info("活动状态变化", zap.Int64("activityId", activityId), zap.String("from", last), zap.String("to", state))
+
+				switch {
+				case state == types.ActivityStateRunning && (!ok || last == types.ActivityStateScheduled):
+					uc.runHooks(ctx, puc, uc.hooks.start)
+				case state == types.ActivityStateEnded:
+					uc.runHooks(ctx, puc, uc.hooks.end)
+				}
+			}
+		}
+	}
+}
+
+func (uc *LotteryUc) runHooks(ctx context.Context, puc IPrizePoolUc, hooks []ActivityHook) {
+	uc.hooks.mu.RLock()
+	list := append([]ActivityHook{}, hooks...)
+	uc.hooks.mu.RUnlock()
+
+	activityId := puc.getActivityId(ctx)
+	for _, hook := range list {
+		func() {
+			defer util.CheckGoPanicWithParam(uc.log, activityId)
+			if err := hook(ctx, puc); err != nil {
+				uc.log.Error("活动钩子执行失败", zap.Int64("activityId", activityId), zap.Error(err))
+			}
+		}()
+	}
+}
+
+// warmPrizePool 活动开始时预热奖池，确保限量奖品库存已写入redis
+func (uc *LotteryUc) warmPrizePool(ctx context.Context, puc IPrizePoolUc) error {
+	activityId := puc.getActivityId(ctx)
+	if stock := puc.getStock(ctx); len(stock) > 0 {
+		if err := uc.stockRd.Sync(ctx, activityId, stock, stockExpireAt(ctx, puc)); err != nil {
+			return err
+		}
+	}
+	uc.log.Info("活动开始 奖池预热完成", zap.Int64("activityId", activityId), zap.Int64("version", puc.getVersion(ctx)))
+	return nil
+}
+
+// closeSeed 活动结束时公开当前周期的种子
+func (uc *LotteryUc) closeSeed(ctx context.Context, puc IPrizePoolUc) error {
+	activityId := puc.getActivityId(ctx)
+	latest, err := uc.seedRepo.Latest(ctx, activityId)
+	if err != nil {
+		return err
+	}
+	if latest == nil || latest.Revealed {
+		return nil
+	}
+	return uc.revealSeed(ctx, activityId, latest.Epoch)
+}
+
+// closeStats 活动结束时统计抽奖数据
+func (uc *LotteryUc) closeStats(ctx context.Context, puc IPrizePoolUc) error {
+	activityId := puc.getActivityId(ctx)
+	stats, err := uc.drawRepo.Stats(ctx, activityId)
+	if err != nil {
+		return err
+	}
+	stock, err := uc.stockRd.Get(ctx, activityId)
+	if err != nil {
+		return err
+	}
+	uc.log.Info("活动结束 抽奖统计", zap.Int64("activityId", activityId), zap.Any("stats", stats), zap.Any("stock", stock))
+	return nil
+}
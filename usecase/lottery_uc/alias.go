@@ -0,0 +1,70 @@
+package lottery_uc
+
+import "math/rand"
+
+// aliasTable Vose别名表，建表O(n)，每次随机O(1)
+// 所有阈值均为整数，抽样概率与权重严格成比例
+type aliasTable struct {
+	prob  []int64 // 第i列保留自身的阈值，范围[0,total]
+	alias []int   // 第i列未保留时取到的下标
+	total int64   // 权重总和，也是每列的容量
+}
+
+// newAliasTable 根据权重创建别名表，权重总和必须大于0
+func newAliasTable(weights []int64) *aliasTable {
+	n := len(weights)
+	t := &aliasTable{
+		prob:  make([]int64, n),
+		alias: make([]int, n),
+	}
+	for _, w := range weights {
+		t.total += w
+	}
+
+	// 权重放大n倍后与每列容量total比较，不足的列由超出的列补齐
+	scaled := make([]int64, n)
+	small := make([]int, 0, n)
+	large := make([]int, 0, n)
+	for i, w := range weights {
+		scaled[i] = w * int64(n)
+		if scaled[i] < t.total {
+			small = append(small, i)
+		} else {
+			large = append(large, i)
+		}
+	}
+	for len(small) > 0 && len(large) > 0 {
+		s := small[len(small)-1]
+		small = small[:len(small)-1]
+		l := large[len(large)-1]
+		large = large[:len(large)-1]
+
+		t.prob[s] = scaled[s]
+		t.alias[s] = l
+		scaled[l] -= t.total - scaled[s]
+		if scaled[l] < t.total {
+			small = append(small, l)
+		} else {
+			large = append(large, l)
+		}
+	}
+	// 整数运算没有误差，剩余的列都恰好装满
+	for _, i := range large {
+		t.prob[i] = t.total
+		t.alias[i] = i
+	}
+	for _, i := range small {
+		t.prob[i] = t.total
+		t.alias[i] = i
+	}
+	return t
+}
+
+// random 按权重随机返回一个下标
+func (t *aliasTable) random(r *rand.Rand) int {
+	i := r.Intn(len(t.prob))
+	if r.Int63n(t.total) < t.prob[i] {
+		return i
+	}
+	return t.alias[i]
+}
@@ -0,0 +1,176 @@
+package lottery_uc
+
+import (
+	"context"
+	"github.com/linchengzhi/lottery/domain/cerror"
+	"github.com/linchengzhi/lottery/domain/dto"
+	"github.com/linchengzhi/lottery/domain/entity"
+	"github.com/linchengzhi/lottery/domain/types"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+)
+
+// 等待用户发奖锁的次数和间隔
+const (
+	awardLockRetry = 40
+	awardLockWait  = 50 * time.Millisecond
+)
+
+// 发奖的货币和物品使用同一个幂等ID，与支付的资产记录区分
+func awardRequestId(requestId string) string {
+	return requestId + "a"
+}
+
+// grantAward 发放货币和物品，使用同一个幂等ID，重复发奖时跳过
+// 有唯一奖品时在用户发奖锁内判断是否已拥有并发放，避免同一用户的并发发奖都发出唯一奖品
+func (uc *LotteryUc) grantAward(ctx context.Context, aStream *dto.AwardStream) error {
+	userId := aStream.PrizeData.UserId
+	if len(aStream.PrizeData.Duplicate) > 0 {
+		token, err := uc.lockAward(ctx, userId)
+		if err != nil {
+			uc.log.Warn("发奖 用户发奖加锁失败", zap.Any("data", aStream), zap.Error(err))
+			return cerror.ErrBusy
+		}
+		defer uc.stateCache.UnlockAward(context.Background(), userId, token)
+	}
+
+	items, at, err := uc.awardGrant(ctx, aStream)
+	if err != nil {
+		return cerror.ErrBusy
+	}
+	key := awardRequestId(aStream.RequestId)
+	err = uc.assetUc.UpdateAsset(ctx, at, key, aStream.RequestTime)
+	if err != nil && !strings.Contains(err.Error(), "Duplicate entry") {
+		uc.log.Error("发奖 更新用户资产失败", zap.Any("data", aStream), zap.Error(err))
+		return cerror.ErrBusy
+	}
+	// 奖品全部为货币时无物品发放
+	if len(items) > 0 {
+		err = uc.assetUc.UpdateItems(ctx, userId, items, key, aStream.RequestTime)
+		if err != nil && !strings.Contains(err.Error(), "Duplicate entry") {
+			uc.log.Error("发奖 更新用户物品失败", zap.Any("data", aStream), zap.Error(err))
+			return cerror.ErrBusy
+		}
+	}
+	return nil
+}
+
+// lockAward 获取用户发奖锁，锁被占用时短暂等待，持有者发奖完成后即释放
+func (uc *LotteryUc) lockAward(ctx context.Context, userId int64) (string, error) {
+	for i := 0; i < awardLockRetry; i++ {
+		token, locked, err := uc.stateCache.LockAward(ctx, userId)
+		if err != nil {
+			return "", err
+		}
+		if locked {
+			return token, nil
+		}
+		time.Sleep(awardLockWait)
+	}
+	return "", cerror.ErrFrequently
+}
+
+// awardGrant 计算本次发放的物品和货币，组合奖品展开为内容，用户已拥有的唯一奖品按规则转换
+func (uc *LotteryUc) awardGrant(ctx context.Context, aStream *dto.AwardStream) (map[int64]int64, *entity.UserAsset, error) {
+	data := aStream.PrizeData
+	var owned map[int64]int64
+	if len(data.Duplicate) > 0 {
+		var err error
+		owned, err = uc.ownedBeforeAward(ctx, aStream)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	prizes := make([]*dto.Item, 0, len(data.Prizes))
+	at := new(entity.UserAsset)
+	at.UserID = data.UserId
+	for _, prize := range data.Prizes {
+		if _, ok := data.Content[prize.Id]; !ok {
+			prizes = append(prizes, prize)
+		}
+	}
+	items, converted := convertDuplicate(prizes, data.Duplicate, owned)
+	data.Converted = converted
+	for _, c := range converted {
+		addCurrency(at, c.Currency, c.Amount)
+	}
+	for _, prize := range data.Prizes {
+		if content, ok := data.Content[prize.Id]; ok {
+			expandContent(items, at, content, prize.Num)
+		}
+	}
+	return items, at, nil
+}
+
+// ownedBeforeAward 获取发奖前用户拥有的物品，重试时物品可能已经发放，扣除本次发放的物品，保证转换结果一致
+func (uc *LotteryUc) ownedBeforeAward(ctx context.Context, aStream *dto.AwardStream) (map[int64]int64, error) {
+	userId := aStream.PrizeData.UserId
+	list, err := uc.assetUc.ListItem(ctx, userId)
+	if err != nil {
+		uc.log.Error("发奖 获取用户物品失败", zap.Any("data", aStream), zap.Error(err))
+		return nil, err
+	}
+	owned := make(map[int64]int64, len(list))
+	for id, num := range list {
+		owned[id] = num
+	}
+	awarded, err := uc.assetUc.GetItemRecord(ctx, userId, awardRequestId(aStream.RequestId))
+	if err != nil {
+		uc.log.Error("发奖 获取物品记录失败", zap.Any("data", aStream), zap.Error(err))
+		return nil, err
+	}
+	for id, num := range awarded {
+		owned[id] -= num
+	}
+	return owned, nil
+}
+
+// convertDuplicate 按用户已拥有的物品转换重复的唯一奖品，唯一奖品最多保留1个，返回发放的物品和转换结果
+func convertDuplicate(prizes []*dto.Item, rules map[int64]*dto.DuplicateConf, owned map[int64]int64) (map[int64]int64, []*dto.Conversion) {
+	items := make(map[int64]int64)
+	var converted []*dto.Conversion
+	for _, prize := range prizes {
+		rule, ok := rules[prize.Id]
+		if !ok {
+			items[prize.Id] += prize.Num
+			continue
+		}
+		num := prize.Num
+		if owned[prize.Id]+items[prize.Id] <= 0 {
+			items[prize.Id]++
+			num--
+		}
+		if num <= 0 {
+			continue
+		}
+		c := &dto.Conversion{PrizeId: prize.Id, Num: num, ItemId: rule.ItemId, Currency: rule.Currency, Amount: num * rule.Num}
+		if rule.ItemId > 0 {
+			items[rule.ItemId] += c.Amount
+		}
+		converted = append(converted, c)
+	}
+	return items, converted
+}
+
+// expandContent 将num个组合奖品的内容累加到发放的物品和货币
+func expandContent(items map[int64]int64, at *entity.UserAsset, content *dto.PrizeContent, num int64) {
+	for _, item := range content.Items {
+		items[item.Id] += item.Num * num
+	}
+	at.Gold += content.Gold * num
+	at.Stone += content.Stone * num
+	at.Crystal += content.Crystal * num
+}
+
+func addCurrency(at *entity.UserAsset, currency string, amount int64) {
+	switch currency {
+	case types.CurrencyGold:
+		at.Gold += amount
+	case types.CurrencyStone:
+		at.Stone += amount
+	case types.CurrencyCrystal:
+		at.Crystal += amount
+	}
+}
@@ -0,0 +1,108 @@
+package lottery_uc
+
+import (
+	"github.com/linchengzhi/lottery/domain/cerror"
+	"github.com/linchengzhi/lottery/domain/dto"
+	"math/rand"
+)
+
+// createBox 箱子模式的奖池，不使用星级和保底
+func (p *PrizePoolUc) createBox(conf dto.BoxConf) error {
+	if len(conf.Prizes) == 0 {
+		return cerror.ErrLotteryConfig
+	}
+	p.box = &conf
+	for _, prize := range conf.Prizes {
+		if prize.Count <= 0 {
+			return cerror.ErrLotteryConfig
+		}
+		p.boxTotal += prize.Count
+	}
+	return nil
+}
+
+func (p *PrizePoolUc) newBox(round int64) *dto.BoxState {
+	box := &dto.BoxState{Round: round, Remaining: make(map[int64]int64, len(p.box.Prizes))}
+	for _, prize := range p.box.Prizes {
+		box.Remaining[prize.Id] += prize.Count
+	}
+	return box
+}
+
+// randomBox 从用户箱子的剩余奖品中不放回抽取，剩余个数不足drawNum时不抽取
+func (p *PrizePoolUc) randomBox(userId, drawNum int64, state *dto.DrawState, r *rand.Rand) (*dto.PrizeData, error) {
+	if state.Box == nil {
+		state.Box = p.newBox(1)
+	}
+	box := state.Box
+	left := int64(0)
+	for _, prize := range p.box.Prizes {
+		left += box.Remaining[prize.Id]
+	}
+	if left < drawNum {
+		return nil, cerror.ErrBoxNotEnough
+	}
+
+	items := make([]*dto.Item, drawNum)
+	topNum := int64(0)
+	for i := range items {
+		// 按配置顺序累加剩余个数，保证相同种子重现相同结果
+		n := r.Int63n(left)
+		for _, prize := range p.box.Prizes {
+			n -= box.Remaining[prize.Id]
+			if n < 0 {
+				box.Remaining[prize.Id]--
+				items[i] = &dto.Item{Id: prize.Id, Num: prize.Num}
+				if prize.Id == p.box.GrandPrize {
+					box.GrandTaken = true
+					topNum++
+				}
+				break
+			}
+		}
+		left--
+		state.DrawTotal++
+	}
+	return &dto.PrizeData{
+		ActivityId:  p.activityId,
+		PoolVersion: p.version,
+		UserId:      userId,
+		Prizes:      items,
+		State:       state,
+		TopNum:      topNum,
+	}, nil
+}
+
+// boxOdds 箱子模式的概率公示，为一箱未抽时的概率
+func (p *PrizePoolUc) boxOdds(resp *dto.OddsResp) *dto.OddsResp {
+	level := &dto.LevelOdds{Rate: 1, Prizes: make([]*dto.PrizeOdds, 0, len(p.box.Prizes))}
+	for _, prize := range p.box.Prizes {
+		level.Prizes = append(level.Prizes, &dto.PrizeOdds{
+			Id:    prize.Id,
+			Num:   prize.Num,
+			Rate:  float64(prize.Count) / float64(p.boxTotal),
+			Stock: prize.Count,
+		})
+	}
+	resp.Levels = []*dto.LevelOdds{level}
+	return resp
+}
+
+// boxResp 用户箱子中剩余的奖品
+func (p *PrizePoolUc) boxResp(userId int64, box *dto.BoxState) *dto.BoxResp {
+	resp := &dto.BoxResp{
+		ActivityId: p.activityId,
+		UserId:     userId,
+		Round:      box.Round,
+		GrandPrize: p.box.GrandPrize,
+		GrandTaken: box.GrandTaken,
+		Total:      p.boxTotal,
+		Prizes:     make([]*dto.BoxItem, 0, len(p.box.Prizes)),
+	}
+	for _, prize := range p.box.Prizes {
+		left := box.Remaining[prize.Id]
+		resp.Left += left
+		resp.Prizes = append(resp.Prizes, &dto.BoxItem{Id: prize.Id, Num: prize.Num, Left: left, Count: prize.Count})
+	}
+	return resp
+}
@@ -0,0 +1,71 @@
+package lottery_uc
+
+import (
+	"context"
+	"github.com/linchengzhi/lottery/domain/cerror"
+	"github.com/linchengzhi/lottery/domain/dto"
+	"go.uber.org/zap"
+)
+
+// Box 获取用户箱子中剩余的奖品，未抽过时为完整的第一箱
+func (uc *LotteryUc) Box(ctx context.Context, req *dto.BoxReq) (*dto.BoxResp, error) {
+	puc, err := uc.getPrizePool(ctx, req.ActivityId)
+	if err != nil {
+		return nil, err
+	}
+	if puc.getNewBox(ctx, 1) == nil {
+		return nil, cerror.ErrNotBox
+	}
+	state, err := uc.getDrawState(ctx, req.ActivityId, req.UserId)
+	if err != nil {
+		uc.log.Warn("获取箱子失败 获取抽奖状态失败", zap.Any("req", req), zap.Error(err))
+		return nil, cerror.ErrBusy
+	}
+	if state.Box == nil {
+		state.Box = puc.getNewBox(ctx, 1)
+	}
+	return puc.getBoxResp(ctx, req.UserId, state.Box), nil
+}
+
+// ResetBox 抽出大奖后将用户的箱子重置为新的一箱，与抽奖使用同一个用户锁
+func (uc *LotteryUc) ResetBox(ctx context.Context, req *dto.BoxReq) (*dto.BoxResp, error) {
+	puc, err := uc.getPrizePool(ctx, req.ActivityId)
+	if err != nil {
+		return nil, err
+	}
+	if puc.getNewBox(ctx, 1) == nil {
+		return nil, cerror.ErrNotBox
+	}
+
+	lockToken, locked, err := uc.stateCache.Lock(ctx, req.ActivityId, req.UserId)
+	if err != nil {
+		uc.log.Warn("重置箱子失败 用户加锁失败", zap.Any("req", req), zap.Error(err))
+		return nil, cerror.ErrBusy
+	}
+	if !locked {
+		return nil, cerror.ErrFrequently
+	}
+	defer uc.stateCache.Unlock(context.Background(), req.ActivityId, req.UserId, lockToken)
+
+	state, err := uc.getDrawState(ctx, req.ActivityId, req.UserId)
+	if err != nil {
+		uc.log.Warn("重置箱子失败 获取抽奖状态失败", zap.Any("req", req), zap.Error(err))
+		return nil, cerror.ErrBusy
+	}
+	if state.Box == nil || !state.Box.GrandTaken {
+		return nil, cerror.ErrBoxNotReset
+	}
+	state.Box = puc.getNewBox(ctx, state.Box.Round+1)
+
+	// 先写mysql，redis失败时删除前也能从mysql恢复新的一箱
+	if err = uc.saveDrawState(ctx, req.ActivityId, req.UserId, state); err != nil {
+		uc.log.Warn("重置箱子失败 保存抽奖状态失败", zap.Any("req", req), zap.Error(err))
+		return nil, cerror.ErrBusy
+	}
+	if err = uc.stateCache.Set(ctx, req.ActivityId, req.UserId, state); err != nil {
+		uc.log.Warn("重置箱子失败 保存抽奖状态缓存失败", zap.Any("req", req), zap.Error(err))
+		return nil, cerror.ErrBusy
+	}
+	uc.log.Info("重置箱子", zap.Any("req", req), zap.Int64("round", state.Box.Round))
+	return puc.getBoxResp(ctx, req.UserId, state.Box), nil
+}
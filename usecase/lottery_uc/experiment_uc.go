@@ -0,0 +1,59 @@
+package lottery_uc
+
+import (
+	"context"
+	"github.com/linchengzhi/lottery/domain/dto"
+	"github.com/linchengzhi/lottery/domain/types"
+	"strconv"
+)
+
+// ExperimentReport 按抽奖记录中的实验分组统计抽奖、花费和最高星级的实际概率
+func (uc *LotteryUc) ExperimentReport(ctx context.Context, activityId int64) (*dto.ExperimentResp, error) {
+	puc, err := uc.getPrizePool(ctx, activityId)
+	if err != nil {
+		return nil, err
+	}
+	stats, spend, err := uc.drawRepo.VariantStats(ctx, activityId)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &dto.ExperimentResp{ActivityId: activityId}
+	reports := make(map[string]*dto.VariantReport)
+	// 当前配置的分组按配置顺序在前，已删除分组的历史记录排在后面
+	for _, v := range puc.getVariants(ctx) {
+		report := &dto.VariantReport{Variant: v.Id, Percent: v.Percent, Spend: make(map[string]int64)}
+		reports[v.Id] = report
+		resp.Variants = append(resp.Variants, report)
+	}
+	for _, s := range stats {
+		report, ok := reports[s.Variant]
+		if !ok {
+			report = &dto.VariantReport{Variant: s.Variant, Spend: make(map[string]int64)}
+			reports[s.Variant] = report
+			resp.Variants = append(resp.Variants, report)
+		}
+		report.Users = s.Users
+		report.Records = s.Records
+		report.Draws = s.Draws
+		report.FreeDraws = s.Free
+		report.TopNum = s.TopNum
+		if s.Draws > 0 {
+			report.TopRate = float64(s.TopNum) / float64(s.Draws)
+		}
+	}
+	for _, s := range spend {
+		if report, ok := reports[s.Variant]; ok {
+			report.Spend[spendKey(s.Currency, s.ItemID)] = s.Amount
+		}
+	}
+	return resp, nil
+}
+
+// spendKey 花费的统计键，物品按物品ID区分，例如 item:2001
+func spendKey(currency string, itemId int64) string {
+	if currency == types.CurrencyItem {
+		return currency + ":" + strconv.FormatInt(itemId, 10)
+	}
+	return currency
+}
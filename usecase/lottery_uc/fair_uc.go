@@ -0,0 +1,322 @@
+package lottery_uc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/bytedance/sonic"
+	"github.com/linchengzhi/lottery/domain/cerror"
+	"github.com/linchengzhi/lottery/domain/dto"
+	"github.com/linchengzhi/lottery/domain/entity"
+	"go.uber.org/zap"
+	mrand "math/rand"
+	"strings"
+	"time"
+)
+
+// fairSeed 生成可验证的抽奖种子 HMAC-SHA256(服务端种子, 客户端种子:用户ID:序号)
+func fairSeed(serverSeed, clientSeed string, userId, nonce int64) []byte {
+	mac := hmac.New(sha256.New, []byte(serverSeed))
+	mac.Write([]byte(fmt.Sprintf("%s:%d:%d", clientSeed, userId, nonce)))
+	return mac.Sum(nil)
+}
+
+func hashSeed(seed string) string {
+	sum := sha256.Sum256([]byte(seed))
+	return hex.EncodeToString(sum[:])
+}
+
+// currentSeed 获取活动当前使用的种子，优先读取redis，最新种子已公开或不存在时创建新周期
+func (uc *LotteryUc) currentSeed(ctx context.Context, activityId int64) (*entity.LotterySeed, error) {
+	seed, err := uc.seedCache.Get(ctx, activityId)
+	if err != nil {
+		return nil, err
+	}
+	if seed != nil {
+		return seed, nil
+	}
+
+	latest, err := uc.seedRepo.Latest(ctx, activityId)
+	if err != nil {
+		return nil, err
+	}
+	if latest == nil || latest.Revealed {
+		return uc.createSeed(ctx, activityId)
+	}
+	if err = uc.seedCache.Set(ctx, latest); err != nil {
+		return nil, err
+	}
+	return latest, nil
+}
+
+// createSeed 创建活动新周期的种子并设置为当前种子
+func (uc *LotteryUc) createSeed(ctx context.Context, activityId int64) (*entity.LotterySeed, error) {
+	for i := 0; i < 3; i++ {
+		latest, err := uc.seedRepo.Latest(ctx, activityId)
+		if err != nil {
+			return nil, err
+		}
+		b, err := uc.rng.NewSeed()
+		if err != nil {
+			return nil, err
+		}
+		s := hex.EncodeToString(b)
+		seed := &entity.LotterySeed{
+			ActivityID: activityId,
+			Epoch:      1,
+			Seed:       s,
+			SeedHash:   hashSeed(s),
+			CreatedAt:  time.Now(),
+		}
+		if latest != nil {
+			seed.Epoch = latest.Epoch + 1
+		}
+		err = uc.seedRepo.Create(ctx, seed)
+		if err != nil {
+			if !strings.Contains(err.Error(), "Duplicate entry") {
+				return nil, err
+			}
+			// 其他实例同时创建了种子，重新读取
+			if latest, err = uc.seedRepo.Latest(ctx, activityId); err != nil {
+				return nil, err
+			}
+			if latest != nil && !latest.Revealed {
+				return latest, uc.seedCache.Set(ctx, latest)
+			}
+			continue
+		}
+		if err = uc.seedCache.Set(ctx, seed); err != nil {
+			return nil, err
+		}
+		uc.log.Info("创建服务端种子", zap.Int64("activityId", activityId), zap.Int64("epoch", seed.Epoch), zap.String("seedHash", seed.SeedHash))
+		return seed, nil
+	}
+	return nil, cerror.ErrBusy
+}
+
+// revealSeed 公开种子，先在mysql标记公开再删除缓存，之后的抽奖会使用新周期的种子
+func (uc *LotteryUc) revealSeed(ctx context.Context, activityId, epoch int64) error {
+	if err := uc.seedRepo.Reveal(ctx, activityId, epoch); err != nil {
+		return err
+	}
+	if err := uc.seedCache.Del(ctx, activityId); err != nil {
+		return err
+	}
+	uc.log.Info("公开服务端种子", zap.Int64("activityId", activityId), zap.Int64("epoch", epoch))
+	return nil
+}
+
+// RotateSeed 开启新的种子周期，并公开上一个周期的种子
+func (uc *LotteryUc) RotateSeed(ctx context.Context, activityId int64) (*dto.SeedEpoch, error) {
+	if _, err := uc.getPrizePool(ctx, activityId); err != nil {
+		return nil, err
+	}
+	latest, err := uc.seedRepo.Latest(ctx, activityId)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := uc.createSeed(ctx, activityId)
+	if err != nil {
+		return nil, err
+	}
+	if latest != nil && !latest.Revealed && latest.Epoch != seed.Epoch {
+		if err = uc.seedRepo.Reveal(ctx, activityId, latest.Epoch); err != nil {
+			return nil, err
+		}
+		uc.log.Info("公开服务端种子", zap.Int64("activityId", activityId), zap.Int64("epoch", latest.Epoch))
+	}
+	return toSeedEpoch(seed), nil
+}
+
+// ListSeeds 获取活动所有种子周期，未公开的种子只返回摘要
+func (uc *LotteryUc) ListSeeds(ctx context.Context, activityId int64) ([]*dto.SeedEpoch, error) {
+	list, err := uc.seedRepo.List(ctx, activityId)
+	if err != nil {
+		return nil, err
+	}
+	resp := make([]*dto.SeedEpoch, 0, len(list))
+	for _, seed := range list {
+		resp = append(resp, toSeedEpoch(seed))
+	}
+	return resp, nil
+}
+
+func toSeedEpoch(seed *entity.LotterySeed) *dto.SeedEpoch {
+	e := &dto.SeedEpoch{
+		ActivityId: seed.ActivityID,
+		Epoch:      seed.Epoch,
+		SeedHash:   seed.SeedHash,
+		CreatedAt:  seed.CreatedAt,
+	}
+	if seed.Revealed {
+		e.Seed = seed.Seed
+		e.RevealedAt = seed.RevealedAt
+	}
+	return e
+}
+
+// fairRand 生成本次抽奖的随机数和可验证参数，序号使用抽奖前的累计抽数
+func (uc *LotteryUc) fairRand(ctx context.Context, req *dto.DrawReq, state *dto.DrawState) (*mrand.Rand, *dto.FairData, error) {
+	seed, err := uc.currentSeed(ctx, req.ActivityId)
+	if err != nil {
+		return nil, nil, err
+	}
+	drawSeed := fairSeed(seed.Seed, req.ClientSeed, req.UserId, state.DrawTotal)
+	fair := &dto.FairData{
+		Seed:              hex.EncodeToString(drawSeed),
+		Epoch:             seed.Epoch,
+		SeedHash:          seed.SeedHash,
+		ClientSeed:        req.ClientSeed,
+		Nonce:             state.DrawTotal,
+		Pity:              state.Pity,
+		FeaturedGuarantee: state.FeaturedGuarantee,
+		Box:               state.Box.Clone(),
+		Step:              state.Step,
+	}
+	return uc.rng.New(drawSeed), fair, nil
+}
+
+// Verify 使用已公开的种子和抽奖时的奖池版本重新计算抽奖结果，并与记录对比
+func (uc *LotteryUc) Verify(ctx context.Context, activityId int64, requestId string) (*dto.VerifyResp, error) {
+	record, err := uc.getDrawRecord(ctx, activityId, requestId)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := uc.seedRepo.Get(ctx, activityId, record.Epoch)
+	if err != nil {
+		return nil, err
+	}
+	if seed == nil || !seed.Revealed {
+		return nil, cerror.ErrSeedNotReveal
+	}
+
+	// 由公开的服务端种子重新生成抽奖种子，不使用记录中保存的种子
+	drawSeed := fairSeed(seed.Seed, record.ClientSeed, record.UserID, record.Nonce)
+	resp, err := uc.replayRecord(ctx, record, drawSeed)
+	if err != nil {
+		return nil, err
+	}
+	resp.Seed = seed.Seed
+	resp.Match = resp.Match && hashSeed(seed.Seed) == record.SeedHash
+	if record.DrawSeed != "" {
+		resp.Match = resp.Match && hex.EncodeToString(drawSeed) == record.DrawSeed
+	}
+	return resp, nil
+}
+
+// Replay 使用抽奖记录中保存的抽奖种子重现抽奖，无需公开服务端种子
+func (uc *LotteryUc) Replay(ctx context.Context, activityId int64, requestId string) (*dto.VerifyResp, error) {
+	record, err := uc.getDrawRecord(ctx, activityId, requestId)
+	if err != nil {
+		return nil, err
+	}
+	drawSeed, err := hex.DecodeString(record.DrawSeed)
+	if err != nil || len(drawSeed) == 0 {
+		return nil, cerror.ErrLotteryNoDraw
+	}
+	return uc.replayRecord(ctx, record, drawSeed)
+}
+
+func (uc *LotteryUc) getDrawRecord(ctx context.Context, activityId int64, requestId string) (*entity.LotteryDrawRecord, error) {
+	record, err := uc.drawRepo.GetByRequestID(ctx, activityId, requestId)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil || record.Epoch == 0 {
+		return nil, cerror.ErrLotteryNoDraw
+	}
+	return record, nil
+}
+
+// replayRecord 使用抽奖时的奖池版本和抽奖前的用户状态，由抽奖种子重新计算抽奖结果
+func (uc *LotteryUc) replayRecord(ctx context.Context, record *entity.LotteryDrawRecord, drawSeed []byte) (*dto.VerifyResp, error) {
+	version, err := uc.versionRepo.Get(ctx, record.ActivityID, record.PoolVersion)
+	if err != nil {
+		return nil, err
+	}
+	if version == nil {
+		return nil, cerror.ErrLotteryConfig
+	}
+	var conf dto.LotteryConf
+	if err = sonic.Unmarshal([]byte(version.Conf), &conf); err != nil {
+		return nil, err
+	}
+	root, err := NewPrizePoolUc(uc.log, conf)
+	if err != nil {
+		return nil, err
+	}
+	// 使用抽奖时的分群奖池
+	puc, err := root.getSegmentPool(ctx, record.Segment)
+	if err != nil {
+		return nil, err
+	}
+	if record.Step > 0 {
+		if puc, err = root.getStepPool(ctx, record.Step-1); err != nil {
+			return nil, err
+		}
+	}
+	if record.Variant != "" {
+		if puc, err = root.getVariantPool(ctx, record.Variant); err != nil {
+			return nil, err
+		}
+	}
+
+	state := &dto.DrawState{
+		Pity:              record.PityBefore,
+		DrawTotal:         record.Nonce,
+		FeaturedGuarantee: record.FeaturedBefore,
+	}
+	if record.BoxBefore != "" {
+		if err = sonic.UnmarshalString(record.BoxBefore, &state.Box); err != nil {
+			return nil, err
+		}
+	}
+	replay, err := puc.RandomPrizes(ctx, record.UserID, int64(record.DrawCount), state, uc.rng.New(drawSeed))
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &dto.VerifyResp{
+		RequestId:   record.RequestID,
+		ActivityId:  record.ActivityID,
+		UserId:      record.UserID,
+		PoolVersion: record.PoolVersion,
+		Segment:     record.Segment,
+		Step:        record.Step,
+		Variant:     record.Variant,
+		Epoch:       record.Epoch,
+		SeedHash:    record.SeedHash,
+		ClientSeed:  record.ClientSeed,
+		Nonce:       record.Nonce,
+		DrawSeed:    hex.EncodeToString(drawSeed),
+		Replay:      replay.Prizes,
+	}
+	if record.Prizes != "" {
+		if err = sonic.Unmarshal([]byte(record.Prizes), &resp.Prizes); err != nil {
+			return nil, err
+		}
+	}
+	resp.Match = matchPrizes(resp.Prizes, resp.Replay, puc)
+	return resp, nil
+}
+
+// matchPrizes 对比记录的奖品和重新计算的奖品，限量奖品库存不足时记录的是替代奖品
+func matchPrizes(prizes, replay []*dto.Item, puc IPrizePoolUc) bool {
+	if len(prizes) != len(replay) {
+		return false
+	}
+	stock := puc.getStock(context.Background())
+	fallback := puc.getFallback(context.Background())
+	for i := range prizes {
+		if prizes[i].Id == replay[i].Id && prizes[i].Num == replay[i].Num {
+			continue
+		}
+		if _, ok := stock[replay[i].Id]; ok && prizes[i].Id == fallback.Id && prizes[i].Num == fallback.Num {
+			continue
+		}
+		return false
+	}
+	return true
+}
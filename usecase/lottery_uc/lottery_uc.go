@@ -2,6 +2,9 @@ package lottery_uc
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"github.com/bytedance/sonic"
 	"github.com/go-redis/redis/v8"
 	"github.com/linchengzhi/lottery/Infra/database/redis_db"
@@ -9,6 +12,7 @@ import (
 	"github.com/linchengzhi/lottery/domain/cerror"
 	"github.com/linchengzhi/lottery/domain/dto"
 	"github.com/linchengzhi/lottery/domain/entity"
+	"github.com/linchengzhi/lottery/domain/types"
 	"github.com/linchengzhi/lottery/repository/mysql_repo"
 	"github.com/linchengzhi/lottery/repository/redis_repo"
 	"github.com/linchengzhi/lottery/usecase/asset_uc"
@@ -23,10 +27,32 @@ import (
 type ILotteryUc interface {
 	// 设置活动奖池
 	SetPrizePool(ctx context.Context, conf dto.LotteryConf) error
+	// 加载多个活动奖池，返回每个活动的加载结果
+	ReloadPrizePool(ctx context.Context, confs []dto.LotteryConf) []*dto.PoolVersion
 	// 抽奖
 	Draw(ctx context.Context, req *dto.DrawReq) (*dto.DrawResp, error)
 	// 奖品列表
 	ListPrizes(ctx context.Context, req *dto.ListPrizeReq) ([]*entity.LotteryPrizeRecord, error)
+	// 开启新的种子周期并公开上一个周期的种子
+	RotateSeed(ctx context.Context, activityId int64) (*dto.SeedEpoch, error)
+	// 活动的种子周期列表
+	ListSeeds(ctx context.Context, activityId int64) ([]*dto.SeedEpoch, error)
+	// 根据已公开的种子验证抽奖结果
+	Verify(ctx context.Context, activityId int64, requestId string) (*dto.VerifyResp, error)
+	// 使用抽奖记录中保存的种子重现抽奖，供客服排查
+	Replay(ctx context.Context, activityId int64, requestId string) (*dto.VerifyResp, error)
+	// 概率公示
+	Odds(ctx context.Context, req *dto.OddsReq) (*dto.OddsResp, error)
+	// 箱子模式用户箱子中剩余的奖品
+	Box(ctx context.Context, req *dto.BoxReq) (*dto.BoxResp, error)
+	// 箱子模式抽出大奖后重置为新的一箱
+	ResetBox(ctx context.Context, req *dto.BoxReq) (*dto.BoxResp, error)
+	// 用户的兑换积分
+	Spark(ctx context.Context, req *dto.SparkReq) (*dto.SparkResp, error)
+	// 使用兑换积分兑换奖品
+	RedeemSpark(ctx context.Context, req *dto.RedeemReq) (*dto.RedeemResp, error)
+	// A/B实验各分组的抽奖、花费和最高星级概率
+	ExperimentReport(ctx context.Context, activityId int64) (*dto.ExperimentResp, error)
 }
 
 // 私有接口，仅在包内使用
@@ -35,6 +61,12 @@ type lotteryInternal interface {
 	getPrizePool(ctx context.Context, activityId int64) (IPrizePoolUc, error)
 	// 抽奖处理逻辑
 	lotteryHandle(ctx context.Context, req *dto.DrawReq) (*dto.PrizeData, error)
+	// 获取用户抽奖状态
+	getDrawState(ctx context.Context, activityId, userId int64) (*dto.DrawState, error)
+	// 扣减限量奖品库存
+	deductStock(ctx context.Context, puc IPrizePoolUc, data *dto.PrizeData) error
+	// 归还限量奖品库存
+	returnStock(ctx context.Context, data *dto.PrizeData) error
 	// 奖品发放
 	award(ctx context.Context, prize *dto.AwardStream) error
 	// 批量插入抽奖记录
@@ -47,18 +79,37 @@ type LotteryUc struct {
 
 	prizeMu   *sync.RWMutex
 	prizePool map[int64]IPrizePoolUc //活动id->奖池
+	hooks     *activityHooks         //活动开始结束钩子
 
 	reqCh    chan *DrawData  //抽奖请求 先入channel等待处理
 	recordCh chan *AwardData //抽奖记录，用于批量插入
 
 	drawRepo     mysql_repo.LotteryDrawRecordRepo
 	prizeRepo    mysql_repo.LotteryPrizeRecordRepo
+	stateRepo    mysql_repo.LotteryUserStateRepo
+	versionRepo  mysql_repo.LotteryPoolVersionRepo
+	seedRepo     mysql_repo.LotterySeedRepo
+	sparkRepo    mysql_repo.LotterySparkRecordRepo
 	lotteryCache redis_repo.LotteryRecordCache
+	stateCache   redis_repo.ILotteryStateRd
+	seedCache    redis_repo.LotterySeedCache
+	limitRd      redis_repo.LotteryLimitRd
+	freeRd       redis_repo.LotteryFreeRd
+	sparkCache   redis_repo.LotterySparkCache
+	stockRd      redis_repo.IPrizePoolRd
 	awardRs      redis_db.IStream
 
-	assetUc asset_uc.AssetUc
+	assetUc  asset_uc.IAssetUc
+	rng      Rng              // 抽奖随机数生成器
+	userAttr UserAttrProvider // 用户属性来源，用于选择分群奖池
 }
 
+// 限量奖品库存在活动结束后的保存时间，用于超时回滚和活动统计
+var prizeStockKeep = time.Duration(7*24) * time.Hour
+
+// 活动累计抽数在redis中的保存时间，计数丢失时以用户的累计抽数为准
+var limitTotalTTL = time.Duration(90*24) * time.Hour
+
 type DrawData struct {
 	req    *dto.DrawReq
 	result chan *dto.DrawResp
@@ -66,33 +117,53 @@ type DrawData struct {
 }
 
 type AwardData struct {
+	activityId   int64
 	drawRecords  []*entity.LotteryDrawRecord
 	prizeRecords []*entity.LotteryPrizeRecord
 	ch           chan error
 }
 
-func NewLotteryUc(log *zap.Logger, g *gpool.Pool, repoMysql mysql_repo.RepoMysql, repoRedis redis_repo.RepoRedis, repoStream redis_repo.RepoStream, assetUc asset_uc.AssetUc) LotteryUc {
+func NewLotteryUc(log *zap.Logger, g *gpool.Pool, repoMysql mysql_repo.RepoMysql, repoRedis redis_repo.RepoRedis, repoStream redis_repo.RepoStream, assetUc asset_uc.AssetUc, rng Rng, userAttr UserAttrProvider) LotteryUc {
 	uc := LotteryUc{
 		log:  log,
 		pool: g,
 
 		prizeMu:   &sync.RWMutex{},
 		prizePool: make(map[int64]IPrizePoolUc),
+		hooks:     &activityHooks{},
 
 		reqCh:    make(chan *DrawData, 10000),
 		recordCh: make(chan *AwardData, 10000),
 
 		drawRepo:     repoMysql.LotteryDrawRecordRepo,
 		prizeRepo:    repoMysql.LotteryPrizeRecordRepo,
+		stateRepo:    repoMysql.LotteryUserStateRepo,
+		versionRepo:  repoMysql.LotteryPoolVersionRepo,
+		seedRepo:     repoMysql.LotterySeedRepo,
+		sparkRepo:    repoMysql.LotterySparkRecordRepo,
 		lotteryCache: repoRedis.LotteryRecordCache,
+		stateCache:   &repoRedis.LotteryStateCache,
+		seedCache:    repoRedis.LotterySeedCache,
+		limitRd:      repoRedis.LotteryLimitRd,
+		freeRd:       repoRedis.LotteryFreeRd,
+		sparkCache:   repoRedis.LotterySparkCache,
+		stockRd:      &repoRedis.PrizePoolRd,
 		awardRs:      repoStream.AwardRs,
 
-		assetUc: assetUc,
+		assetUc:  &assetUc,
+		rng:      rng,
+		userAttr: userAttr,
 	}
 	go uc.lotteryCache.GetTimeout(context.Background(), uc.RollbackCallBack)
 	go uc.processDrawData(context.Background())
 	go uc.awardRs.Get(uc.AwardCallBack)
 	go uc.processAwardData(context.Background())
+
+	uc.AddStartHook(uc.warmPrizePool)
+	uc.AddEndHook(uc.closeStats)
+	uc.AddEndHook(uc.closeSeed)
+	uc.AddEndHook(uc.expireSpark)
+	go uc.processActivityState(context.Background())
 	return uc
 }
 
@@ -121,24 +192,34 @@ func (uc *LotteryUc) processDrawData(ctx context.Context) {
 		case data := <-uc.reqCh:
 			uc.pool.Submit(func() {
 				resp, err := uc.lotteryHandle(data.ctx, data.req)
-				data.result <- &dto.DrawResp{
+				drawResp := &dto.DrawResp{
 					RequestId: data.req.RequestId,
 					PrizeData: resp,
 					Err:       err,
 				}
+				if resp != nil && resp.State != nil {
+					drawResp.Pity = resp.State.Pity
+				}
+				data.result <- drawResp
 			})
 		}
 	}
 }
 
+// SetPrizePool 设置活动奖池，新奖池准备完成后才替换旧奖池，进行中的抽奖继续使用旧奖池
 func (uc *LotteryUc) SetPrizePool(ctx context.Context, conf dto.LotteryConf) error {
+	if errs := ValidateLotteryConf(conf); len(errs) > 0 {
+		return cerror.ErrLotteryConfig.WithErr(errs)
+	}
+	// 保存原始配置用于生成奖池版本，验证抽奖时据此重建奖池
+	confJson, err := sonic.Marshal(conf)
+	if err != nil {
+		return err
+	}
 	puc, err := NewPrizePoolUc(uc.log, conf)
 	if err != nil {
 		return err
 	}
-	uc.prizeMu.Lock()
-	uc.prizePool[conf.ActivityId] = puc
-	uc.prizeMu.Unlock()
 	err = uc.drawRepo.CreateTable(ctx, conf.ActivityId)
 	if err != nil {
 		return err
@@ -147,10 +228,104 @@ func (uc *LotteryUc) SetPrizePool(ctx context.Context, conf dto.LotteryConf) err
 	if err != nil {
 		return err
 	}
-	uc.log.Info("设置奖池成功", zap.Int64("activityId", conf.ActivityId))
+	err = uc.stateRepo.CreateTable(ctx, conf.ActivityId)
+	if err != nil {
+		return err
+	}
+	if conf.Spark.PerDraw > 0 {
+		if err = uc.sparkRepo.CreateTable(ctx, conf.ActivityId); err != nil {
+			return err
+		}
+	}
+	// 同步限量奖品库存，已存在时保留剩余库存并按配置的变化量调整
+	if stock := puc.getStock(ctx); len(stock) > 0 {
+		err = uc.stockRd.Sync(ctx, conf.ActivityId, stock, stockExpireAt(ctx, puc))
+		if err != nil {
+			return err
+		}
+	}
+	// 确保活动有当前周期的种子，抽奖前即可公布种子摘要
+	if _, err = uc.currentSeed(ctx, conf.ActivityId); err != nil {
+		return err
+	}
+	version, err := uc.savePoolVersion(ctx, conf.ActivityId, confJson)
+	if err != nil {
+		return err
+	}
+	puc.setVersion(version)
+
+	uc.prizeMu.Lock()
+	uc.prizePool[conf.ActivityId] = puc
+	uc.prizeMu.Unlock()
+	uc.log.Info("设置奖池成功", zap.Int64("activityId", conf.ActivityId), zap.Int64("version", version))
 	return nil
 }
 
+// ReloadPrizePool 加载多个活动的奖池，各活动独立加载，单个活动失败不影响其他活动
+func (uc *LotteryUc) ReloadPrizePool(ctx context.Context, confs []dto.LotteryConf) []*dto.PoolVersion {
+	versions := make([]*dto.PoolVersion, 0, len(confs))
+	loaded := make(map[int64]bool)
+	for _, conf := range confs {
+		v := &dto.PoolVersion{ActivityId: conf.ActivityId}
+		versions = append(versions, v)
+		if loaded[conf.ActivityId] {
+			v.Err = "活动ID重复"
+			uc.log.Error("加载奖池失败 活动ID重复", zap.Int64("activityId", conf.ActivityId))
+			continue
+		}
+		loaded[conf.ActivityId] = true
+
+		err := uc.SetPrizePool(ctx, conf)
+		if err != nil {
+			v.Err = err.Error()
+			var confErrs dto.ConfErrors
+			if errors.As(err, &confErrs) {
+				v.Errors = confErrs
+			}
+			uc.log.Error("加载奖池失败", zap.Int64("activityId", conf.ActivityId), zap.Error(err))
+			continue
+		}
+		if puc, err := uc.getPrizePool(ctx, conf.ActivityId); err == nil {
+			v.Version = puc.getVersion(ctx)
+		}
+	}
+	return versions
+}
+
+// savePoolVersion 获取奖池配置对应的版本号，配置与最新版本不同时生成新版本
+func (uc *LotteryUc) savePoolVersion(ctx context.Context, activityId int64, confJson []byte) (int64, error) {
+	sum := sha256.Sum256(confJson)
+	hash := hex.EncodeToString(sum[:])
+	for i := 0; i < 3; i++ {
+		latest, err := uc.versionRepo.Latest(ctx, activityId)
+		if err != nil {
+			return 0, err
+		}
+		if latest != nil && latest.Hash == hash {
+			return latest.Version, nil
+		}
+		v := &entity.LotteryPoolVersion{
+			ActivityID: activityId,
+			Version:    1,
+			Hash:       hash,
+			Conf:       string(confJson),
+			CreatedAt:  time.Now(),
+		}
+		if latest != nil {
+			v.Version = latest.Version + 1
+		}
+		err = uc.versionRepo.Create(ctx, v)
+		if err == nil {
+			return v.Version, nil
+		}
+		if !strings.Contains(err.Error(), "Duplicate entry") {
+			return 0, err
+		}
+		// 其他实例同时创建了版本，重新读取
+	}
+	return 0, cerror.ErrBusy
+}
+
 func (uc *LotteryUc) getPrizePool(ctx context.Context, activityId int64) (IPrizePoolUc, error) {
 	uc.prizeMu.RLock()
 	defer uc.prizeMu.RUnlock()
@@ -223,28 +398,145 @@ func (uc *LotteryUc) lotteryHandle(ctx context.Context, req *dto.DrawReq) (*dto.
 	if err != nil {
 		return nil, err
 	}
+	switch puc.getState(ctx, time.Now()) {
+	case types.ActivityStateScheduled:
+		return nil, cerror.ErrLotteryNoStart
+	case types.ActivityStatePaused:
+		return nil, cerror.ErrLotteryPaused
+	case types.ActivityStateEnded:
+		return nil, cerror.ErrLotteryEnded
+	}
+
+	if limit := puc.getLimit(ctx); limit.PerRequest > 0 && req.DrawNum > limit.PerRequest {
+		return nil, cerror.ErrDrawNumLimit
+	}
+	// 免费抽奖只能单抽，占用请求时间所在的周期
+	var freePeriod string
+	var freeTTL time.Duration
+	if req.Free {
+		var ok bool
+		if freePeriod, freeTTL, ok = puc.getFree(ctx, req.RequestTime); !ok {
+			return nil, cerror.ErrNoFree
+		}
+		if req.DrawNum != 1 {
+			return nil, cerror.ErrDrawNumLimit
+		}
+	}
+	// 按用户属性选择分群奖池，分群共用活动的价格和抽数限制
+	puc, err = uc.segmentPool(ctx, puc, req)
+	if err != nil {
+		return nil, cerror.ErrBusy
+	}
+	// 按用户ID分配实验分组，分组的价格在加锁后选择
+	puc = puc.matchVariant(ctx, req.UserId)
+
+	// 用户抽奖加锁，保证保底等用户状态串行更新
+	lockToken, locked, err := uc.stateCache.Lock(ctx, req.ActivityId, req.UserId)
+	if err != nil {
+		uc.log.Warn("抽奖失败 用户加锁失败", zap.Any("req", req), zap.Error(err))
+		return nil, cerror.ErrBusy
+	}
+	if !locked {
+		return nil, cerror.ErrFrequently
+	}
+	defer uc.stateCache.Unlock(context.Background(), req.ActivityId, req.UserId, lockToken)
+
+	state, err := uc.getDrawState(ctx, req.ActivityId, req.UserId)
+	if err != nil {
+		uc.log.Warn("抽奖失败 获取抽奖状态失败", zap.Any("req", req), zap.Error(err))
+		return nil, cerror.ErrBusy
+	}
+	// 箱子模式首次抽奖时生成第一箱，抽奖前的箱子状态会记录用于重现抽奖
+	if state.Box == nil {
+		state.Box = puc.getNewBox(ctx, 1)
+	}
+	// 阶梯模式按已完成的阶梯数选择当前阶梯，阶梯数在用户锁内与扣除资产一起更新
+	pricing := puc.getPricing(ctx)
+	if steps := puc.getSteps(ctx); len(steps) > 0 {
+		index := int(state.Step % int64(len(steps)))
+		if req.DrawNum != steps[index].DrawNum {
+			return nil, cerror.ErrStepDrawNum
+		}
+		if puc, err = puc.getStepPool(ctx, index); err != nil {
+			return nil, err
+		}
+		pricing = puc.getPricing(ctx)
+	}
+	pricing, err = selectPricing(pricing, req.Currency, req.ItemId)
+	if err != nil {
+		return nil, err
+	}
 
 	// 2. 随机抽取奖品
 	//randomSpan, _ := opentracing.StartSpanFromContext(ctx, "random_prizes")
 	//defer randomSpan.Finish()
-	prizesData, err := puc.RandomPrizes(ctx, req.UserId, req.DrawNum)
+	r, fair, err := uc.fairRand(ctx, req, state)
+	if err != nil {
+		uc.log.Warn("抽奖失败 获取服务端种子失败", zap.Any("req", req), zap.Error(err))
+		return nil, cerror.ErrBusy
+	}
+	prizesData, err := puc.RandomPrizes(ctx, req.UserId, req.DrawNum, state, r)
 	if err != nil {
 		uc.log.Warn("抽奖失败 随机奖品失败", zap.Any("req", req), zap.Error(err))
 		return nil, err
 	}
+	prizesData.Fair = fair
+
+	// 占用抽数限制，必须在扣除资产前
+	err = uc.takeLimit(ctx, puc, req, state.DrawTotal-req.DrawNum, prizesData)
+	if err != nil {
+		return nil, err
+	}
+
+	// 扣减限量奖品库存
+	err = uc.deductStock(ctx, puc, prizesData)
+	if err != nil {
+		uc.log.Warn("抽奖失败 扣减奖品库存失败", zap.Any("req", req), zap.Error(err))
+		req.PrizesData = prizesData
+		uc.cancelDraw(ctx, req)
+		return nil, cerror.ErrBusy
+	}
+	// 替代奖品确定后记录唯一奖品的转换规则和组合奖品的内容，发奖时使用
+	prizesData.Duplicate = puc.getDuplicate(ctx, prizesData.Prizes)
+	prizesData.Content = puc.getContent(ctx, prizesData.Prizes)
+	prizesData.Spark = puc.getSpark(ctx).PerDraw * req.DrawNum
+	if req.Free {
+		prizesData.Free = &dto.FreeData{Period: freePeriod}
+	}
+
+	// 记录抽奖结果，未完成的抽奖超时后据此回滚
+	req.PrizesData = prizesData
+	err = uc.lotteryCache.Set(ctx, req)
+	if err != nil {
+		uc.log.Warn("抽奖失败 设置缓存记录失败", zap.Any("req", req), zap.Error(err))
+		uc.cancelDraw(ctx, req)
+		return nil, cerror.ErrBusy
+	}
 
 	// 3. 扣除用户资产
 	//assetSpan, assetCtx := opentracing.StartSpanFromContext(ctx, "update_asset")
 	//defer assetSpan.Finish()
-	at := new(entity.UserAsset)
-	at.UserID = req.UserId
-	at.Stone = -puc.getPrice(ctx) * req.DrawNum
-	err = uc.assetUc.UpdateAsset(ctx, at, req.RequestId, req.RequestTime)
+	if req.Free {
+		err = uc.takeFree(ctx, req, freeTTL)
+	} else {
+		err = uc.payDraw(ctx, pricing, req, prizesData)
+	}
 	if err != nil {
 		uc.log.Warn("抽奖失败 更新资产失败", zap.Any("req", req), zap.Error(err))
+		// 资产不足或免费次数已用完时确定未扣除，直接取消；其他错误无法确定结果，交给超时回滚处理
+		if isPayLess(err) || err == cerror.ErrFreeUsed {
+			uc.cancelDraw(ctx, req)
+		}
 		return nil, err
 	}
 
+	// 保存抽奖状态，失败时撤销本次抽奖，避免保底和箱子状态与已扣除的资产不一致
+	if err = uc.stateCache.Set(ctx, req.ActivityId, req.UserId, prizesData.State); err != nil {
+		uc.log.Warn("抽奖失败 保存抽奖状态失败", zap.Any("req", req), zap.Error(err))
+		uc.abortDraw(ctx, req)
+		return nil, cerror.ErrBusy
+	}
+
 	// 4. 保存奖品列表到 redis stream
 	//streamSpan, _ := opentracing.StartSpanFromContext(ctx, "save_to_stream")
 	//defer streamSpan.Finish()
@@ -256,6 +548,8 @@ func (uc *LotteryUc) lotteryHandle(ctx context.Context, req *dto.DrawReq) (*dto.
 	byteAs, _ := sonic.Marshal(awardStream)
 	_, err = uc.awardRs.Add(string(byteAs))
 	if err != nil {
+		uc.log.Warn("抽奖失败 保存发奖消息失败", zap.Any("req", req), zap.Error(err))
+		uc.abortDraw(ctx, req)
 		return nil, err
 	}
 
@@ -271,6 +565,251 @@ func (uc *LotteryUc) lotteryHandle(ctx context.Context, req *dto.DrawReq) (*dto.
 	return prizesData, nil
 }
 
+// saveDrawState 将用户抽奖状态备份到mysql
+func (uc *LotteryUc) saveDrawState(ctx context.Context, activityId, userId int64, state *dto.DrawState) error {
+	record := &entity.LotteryUserState{
+		ActivityID:        activityId,
+		UserID:            userId,
+		Pity:              state.Pity,
+		DrawTotal:         state.DrawTotal,
+		FeaturedGuarantee: state.FeaturedGuarantee,
+		Step:              state.Step,
+	}
+	if state.Box != nil {
+		boxJson, err := sonic.Marshal(state.Box)
+		if err != nil {
+			return err
+		}
+		record.Box = string(boxJson)
+		record.BoxRound = state.Box.Round
+	}
+	return uc.stateRepo.Save(ctx, record)
+}
+
+// getDrawState 获取用户抽奖状态，优先读取redis，未命中时从mysql恢复
+func (uc *LotteryUc) getDrawState(ctx context.Context, activityId, userId int64) (*dto.DrawState, error) {
+	state, err := uc.stateCache.Get(ctx, activityId, userId)
+	if err != nil {
+		return nil, err
+	}
+	if state != nil {
+		return state, nil
+	}
+
+	record, err := uc.stateRepo.Get(ctx, activityId, userId)
+	if err != nil {
+		return nil, err
+	}
+	state = new(dto.DrawState)
+	if record != nil {
+		state.Pity = record.Pity
+		state.DrawTotal = record.DrawTotal
+		state.FeaturedGuarantee = record.FeaturedGuarantee
+		state.Step = record.Step
+		if record.Box != "" {
+			if err = sonic.UnmarshalString(record.Box, &state.Box); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return state, nil
+}
+
+// stockExpireAt 限量奖品库存的过期时间，活动不结束时不过期
+func stockExpireAt(ctx context.Context, puc IPrizePoolUc) time.Time {
+	endTime := puc.getEndTime(ctx)
+	if endTime.IsZero() {
+		return time.Time{}
+	}
+	return endTime.Add(prizeStockKeep)
+}
+
+// deductStock 扣减限量奖品库存，库存不足的奖品从后往前替换为替代奖品，实际扣减的库存记录在 data.Stock
+func (uc *LotteryUc) deductStock(ctx context.Context, puc IPrizePoolUc, data *dto.PrizeData) error {
+	stock := puc.getStock(ctx)
+	if len(stock) == 0 {
+		return nil
+	}
+	need := make(map[int64]int64)
+	for _, item := range data.Prizes {
+		if _, ok := stock[item.Id]; ok {
+			need[item.Id]++
+		}
+	}
+	if len(need) == 0 {
+		return nil
+	}
+
+	granted, err := uc.stockRd.DecrBy(ctx, data.ActivityId, need)
+	if err != nil {
+		return err
+	}
+	data.Stock = granted
+
+	fallback := puc.getFallback(ctx)
+	for i := len(data.Prizes) - 1; i >= 0; i-- {
+		id := data.Prizes[i].Id
+		if _, ok := need[id]; !ok || need[id] <= granted[id] {
+			continue
+		}
+		need[id]--
+		data.Prizes[i] = &dto.Item{Id: fallback.Id, Num: fallback.Num}
+		uc.log.Info("抽奖 限量奖品库存不足，发放替代奖品", zap.Int64("activityId", data.ActivityId), zap.Int64("prizeId", id))
+	}
+	return nil
+}
+
+// returnStock 归还抽奖扣减的限量奖品库存
+func (uc *LotteryUc) returnStock(ctx context.Context, data *dto.PrizeData) error {
+	if data == nil || len(data.Stock) == 0 {
+		return nil
+	}
+	return uc.stockRd.IncrBy(ctx, data.ActivityId, data.Stock)
+}
+
+// selectPricing 获取可用的价格表，指定货币时只使用该货币，指定物品时只使用该物品
+func selectPricing(pricing []dto.PriceConf, currency string, itemId int64) ([]dto.PriceConf, error) {
+	if currency == "" {
+		return pricing, nil
+	}
+	result := make([]dto.PriceConf, 0, 1)
+	for _, price := range pricing {
+		if price.Currency == currency && (itemId == 0 || price.ItemId == itemId) {
+			result = append(result, price)
+		}
+	}
+	if len(result) == 0 {
+		return nil, cerror.ErrCurrencyLimit
+	}
+	return result, nil
+}
+
+// 物品支付的记录ID，与发奖的物品记录区分
+func itemPayRequestId(requestId string) string {
+	return requestId + "t"
+}
+
+// 按价格表顺序扣除资产或物品，余额不足时尝试下一种，成功后记录实际花费
+func (uc *LotteryUc) payDraw(ctx context.Context, pricing []dto.PriceConf, req *dto.DrawReq, data *dto.PrizeData) error {
+	var err error = cerror.ErrAssetLess
+	for _, price := range pricing {
+		cost := price.Cost(req.DrawNum)
+		if price.Currency == types.CurrencyItem {
+			items := map[int64]int64{price.ItemId: -cost}
+			err = uc.assetUc.UpdateItems(ctx, req.UserId, items, itemPayRequestId(req.RequestId), req.RequestTime)
+		} else {
+			at := costAsset(req.UserId, price.Currency, cost)
+			err = uc.assetUc.UpdateAsset(ctx, at, req.RequestId, req.RequestTime)
+		}
+		if isPayLess(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		data.Amount = cost
+		data.Currency = price.Currency
+		data.ItemId = price.ItemId
+		return nil
+	}
+	return err
+}
+
+// 资产或物品不足，确定未扣除
+func isPayLess(err error) bool {
+	return err == cerror.ErrAssetLess || err == cerror.ErrItemLess
+}
+
+// 生成扣除指定货币的资产变更
+func costAsset(userId int64, currency string, cost int64) *entity.UserAsset {
+	at := new(entity.UserAsset)
+	at.UserID = userId
+	switch currency {
+	case types.CurrencyGold:
+		at.Gold = -cost
+	case types.CurrencyStone:
+		at.Stone = -cost
+	case types.CurrencyCrystal:
+		at.Crystal = -cost
+	}
+	return at
+}
+
+// takeLimit 检查并占用用户的抽数限制，drawTotal为本次抽奖前的累计抽数
+func (uc *LotteryUc) takeLimit(ctx context.Context, puc IPrizePoolUc, req *dto.DrawReq, drawTotal int64, data *dto.PrizeData) error {
+	limit := puc.getLimit(ctx)
+	if limit.Daily <= 0 && limit.Total <= 0 {
+		return nil
+	}
+	day := puc.getLimitDay(ctx, time.Now())
+	code, err := uc.limitRd.Take(ctx, req.ActivityId, req.UserId, day, req.DrawNum, redis_repo.LimitArgs{
+		Daily:      limit.Daily,
+		Total:      limit.Total,
+		TotalFloor: drawTotal,
+		TotalTTL:   limitTotalTTL,
+	})
+	if err != nil {
+		uc.log.Warn("抽奖失败 检查抽数限制失败", zap.Any("req", req), zap.Error(err))
+		return cerror.ErrBusy
+	}
+	switch code {
+	case redis_repo.LimitDaily:
+		return cerror.ErrDailyLimit
+	case redis_repo.LimitTotal:
+		return cerror.ErrTotalLimit
+	}
+	data.Limit = &dto.LimitData{Day: day, Num: req.DrawNum}
+	return nil
+}
+
+// takeFree 原子占用免费抽奖，代替扣除资产
+func (uc *LotteryUc) takeFree(ctx context.Context, req *dto.DrawReq, ttl time.Duration) error {
+	ok, err := uc.freeRd.Take(ctx, req.ActivityId, req.UserId, req.PrizesData.Free.Period, req.RequestId, ttl)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return cerror.ErrFreeUsed
+	}
+	uc.log.Info("免费抽奖", zap.Int64("activityId", req.ActivityId), zap.Int64("userId", req.UserId),
+		zap.String("requestId", req.RequestId), zap.String("period", req.PrizesData.Free.Period))
+	return nil
+}
+
+// undoFree 撤销本次抽奖占用的免费抽奖
+func (uc *LotteryUc) undoFree(ctx context.Context, req *dto.DrawReq) error {
+	if req.PrizesData == nil || req.PrizesData.Free == nil {
+		return nil
+	}
+	return uc.freeRd.Undo(ctx, req.ActivityId, req.UserId, req.PrizesData.Free.Period, req.RequestId)
+}
+
+// undoLimit 撤销抽奖占用的抽数限制，同一请求只撤销一次
+func (uc *LotteryUc) undoLimit(ctx context.Context, req *dto.DrawReq) error {
+	if req.PrizesData == nil || req.PrizesData.Limit == nil {
+		return nil
+	}
+	limit := req.PrizesData.Limit
+	return uc.limitRd.Undo(ctx, req.ActivityId, req.UserId, limit.Day, limit.Num, req.RequestId)
+}
+
+// cancelDraw 抽奖未扣除资产就失败时，撤销抽数限制、归还库存并删除缓存；失败时保留缓存，由超时回滚重试
+func (uc *LotteryUc) cancelDraw(ctx context.Context, req *dto.DrawReq) {
+	if err := uc.undoFree(ctx, req); err != nil {
+		uc.log.Warn("抽奖失败 撤销免费抽奖失败", zap.Any("req", req), zap.Error(err))
+		return
+	}
+	if err := uc.undoLimit(ctx, req); err != nil {
+		uc.log.Warn("抽奖失败 撤销抽数限制失败", zap.Any("req", req), zap.Error(err))
+		return
+	}
+	if err := uc.returnStock(ctx, req.PrizesData); err != nil {
+		uc.log.Warn("抽奖失败 归还奖品库存失败", zap.Any("req", req), zap.Error(err))
+		return
+	}
+	uc.lotteryCache.Del(ctx, req.RequestId)
+}
+
 var awardDataPool = sync.Pool{
 	New: func() interface{} {
 		return &AwardData{
@@ -280,42 +819,79 @@ var awardDataPool = sync.Pool{
 }
 
 func (uc *LotteryUc) award(ctx context.Context, aStream *dto.AwardStream) error {
-	var err error
-	var items = make(map[int64]int64)
-	for _, v := range aStream.PrizeData.Prizes {
-		items[v.Id] += v.Num
+	// 1. 发放货币和物品
+	err := uc.grantAward(ctx, aStream)
+	if err != nil {
+		return err
 	}
 	currentTime := time.Now()
-	// 1. 更新用户物品数据
-	err = uc.assetUc.UpdateItems(ctx, aStream.PrizeData.UserId, items, aStream.RequestId, aStream.RequestTime)
-	if err != nil {
-		if strings.Contains(err.Error(), "Duplicate entry") {
-			// 重复发奖，跳过发奖，继续
-		} else {
+	// 计入兑换积分
+	if err = uc.addSpark(ctx, aStream); err != nil {
+		uc.log.Error("发奖 增加兑换积分失败", zap.Any("data", aStream), zap.Error(err))
+		return cerror.ErrBusy
+	}
+	// 备份用户抽奖状态
+	if state := aStream.PrizeData.State; state != nil {
+		err = uc.saveDrawState(ctx, aStream.PrizeData.ActivityId, aStream.PrizeData.UserId, state)
+		if err != nil {
+			uc.log.Error("发奖 保存抽奖状态失败", zap.Any("data", aStream), zap.Error(err))
 			return cerror.ErrBusy
 		}
-
 	}
 	// 2. 插入抽奖记录
 	var prizeRecords = make([]*entity.LotteryPrizeRecord, 0)
 	for _, v := range aStream.PrizeData.Prizes {
-		prizeRecords = append(prizeRecords, &entity.LotteryPrizeRecord{
+		prizeRecord := &entity.LotteryPrizeRecord{
 			ActivityID: aStream.PrizeData.ActivityId,
 			UserID:     aStream.PrizeData.UserId,
 			PrizeID:    v.Id,
 			PrizeNum:   v.Num,
 			CreatedAt:  currentTime,
-		})
+		}
+		if content, ok := aStream.PrizeData.Content[v.Id]; ok {
+			contentJson, _ := sonic.Marshal(content)
+			prizeRecord.Content = string(contentJson)
+		}
+		prizeRecords = append(prizeRecords, prizeRecord)
 	}
 	record := new(entity.LotteryDrawRecord)
 	record.ActivityID = aStream.PrizeData.ActivityId
 	record.UserID = aStream.PrizeData.UserId
 	record.DrawCount = len(aStream.PrizeData.Prizes)
+	record.PoolVersion = aStream.PrizeData.PoolVersion
+	record.Segment = aStream.PrizeData.Segment
+	record.Step = aStream.PrizeData.Step
+	record.Free = aStream.PrizeData.Free != nil
+	record.Variant = aStream.PrizeData.Variant
+	record.Amount = aStream.PrizeData.Amount
+	record.Currency = aStream.PrizeData.Currency
+	record.ItemID = aStream.PrizeData.ItemId
+	record.TopNum = aStream.PrizeData.TopNum
 	record.RequestID = aStream.RequestId
 	record.CreatedAt = currentTime
+	if fair := aStream.PrizeData.Fair; fair != nil {
+		record.Epoch = fair.Epoch
+		record.SeedHash = fair.SeedHash
+		record.ClientSeed = fair.ClientSeed
+		record.Nonce = fair.Nonce
+		record.DrawSeed = fair.Seed
+		record.PityBefore = fair.Pity
+		record.FeaturedBefore = fair.FeaturedGuarantee
+		if fair.Box != nil {
+			boxJson, _ := sonic.Marshal(fair.Box)
+			record.BoxBefore = string(boxJson)
+		}
+	}
+	prizesJson, _ := sonic.Marshal(aStream.PrizeData.Prizes)
+	record.Prizes = string(prizesJson)
+	if len(aStream.PrizeData.Converted) > 0 {
+		convertedJson, _ := sonic.Marshal(aStream.PrizeData.Converted)
+		record.Converted = string(convertedJson)
+	}
 
 	ad := awardDataPool.Get().(*AwardData)
 	defer awardDataPool.Put(ad)
+	ad.activityId = record.ActivityID
 	ad.drawRecords = []*entity.LotteryDrawRecord{record}
 	ad.prizeRecords = prizeRecords
 	uc.recordCh <- ad
@@ -330,19 +906,31 @@ func (uc *LotteryUc) processAwardData(ctx context.Context) {
 	var buffer []*AwardData // 用于批量处理的缓冲区
 
 	var writeRecord = func() {
-		// 合并所有 AwardData 的 drawRecords 和 prizeRecords
-		var allDrawRecords []*entity.LotteryDrawRecord
-		var allPrizeRecords []*entity.LotteryPrizeRecord
-
+		// 按活动分组合并 AwardData 的 drawRecords 和 prizeRecords，各活动单独写入，互不影响
+		groups := make(map[int64][]*AwardData)
+		var activityIds []int64
 		for _, awardData := range buffer {
-			allDrawRecords = append(allDrawRecords, awardData.drawRecords...)
-			allPrizeRecords = append(allPrizeRecords, awardData.prizeRecords...)
+			if _, ok := groups[awardData.activityId]; !ok {
+				activityIds = append(activityIds, awardData.activityId)
+			}
+			groups[awardData.activityId] = append(groups[awardData.activityId], awardData)
 		}
 
-		// 模拟插入数据库
-		err := uc.drawRepo.BatchCreate(ctx, allDrawRecords, allPrizeRecords)
-		for _, awardData := range buffer {
-			awardData.ch <- err // 通知插入数据库的结果
+		for _, activityId := range activityIds {
+			var allDrawRecords []*entity.LotteryDrawRecord
+			var allPrizeRecords []*entity.LotteryPrizeRecord
+			for _, awardData := range groups[activityId] {
+				allDrawRecords = append(allDrawRecords, awardData.drawRecords...)
+				allPrizeRecords = append(allPrizeRecords, awardData.prizeRecords...)
+			}
+
+			err := uc.drawRepo.BatchCreate(ctx, allDrawRecords, allPrizeRecords)
+			if err != nil {
+				uc.log.Error("批量写入抽奖记录失败", zap.Int64("activityId", activityId), zap.Error(err))
+			}
+			for _, awardData := range groups[activityId] {
+				awardData.ch <- err // 通知插入数据库的结果
+			}
 		}
 		// 清空缓冲区
 		buffer = []*AwardData{}
@@ -376,6 +964,9 @@ func (uc *LotteryUc) ListPrizes(ctx context.Context, req *dto.ListPrizeReq) ([]*
 }
 
 func (uc *LotteryUc) RollbackCallBack(req *dto.DrawReq) error {
+	if req == nil {
+		return nil
+	}
 	var err error
 	defer func() {
 		if err == nil {
@@ -383,9 +974,6 @@ func (uc *LotteryUc) RollbackCallBack(req *dto.DrawReq) error {
 		}
 	}()
 
-	if req == nil {
-		return nil
-	}
 	if req.PrizesData == nil { //无需回滚
 		return nil
 	}
@@ -393,30 +981,135 @@ func (uc *LotteryUc) RollbackCallBack(req *dto.DrawReq) error {
 	if req.RequestTime.Before(time.Now().Add(-10 * time.Minute)) {
 		return nil // 超过10分钟，无需回滚
 	}
+
+	// 回滚用户状态需要与抽奖串行，加锁失败时等待下次重试
+	lockToken, locked, err := uc.stateCache.Lock(context.Background(), req.ActivityId, req.UserId)
+	if err != nil {
+		uc.log.Warn("抽奖失败 回滚加锁失败", zap.Any("req", req), zap.Error(err))
+		return err
+	}
+	if !locked {
+		err = cerror.ErrFrequently
+		return err
+	}
+	defer uc.stateCache.Unlock(context.Background(), req.ActivityId, req.UserId, lockToken)
+
+	err = uc.rollbackDraw(context.Background(), req)
+	return err
+}
+
+// abortDraw 扣除资产后抽奖未能完成时立即回滚，回滚失败时保留缓存记录，交给超时回滚处理
+func (uc *LotteryUc) abortDraw(ctx context.Context, req *dto.DrawReq) {
+	if err := uc.rollbackDraw(ctx, req); err != nil {
+		return
+	}
+	uc.lotteryCache.Del(ctx, req.RequestId)
+}
+
+// rollbackDraw 退还已扣除的资产，撤销免费抽奖、抽数限制和库存，并恢复抽奖前的用户状态，调用方需持有用户锁
+func (uc *LotteryUc) rollbackDraw(ctx context.Context, req *dto.DrawReq) error {
+	var err error
 	//读取资产记录
 	var record *entity.UserAssetRecord
-	record, err = uc.assetUc.GetAssetRecord(context.Background(), req.RequestId)
+	record, err = uc.assetUc.GetAssetRecord(ctx, req.UserId, req.RequestId)
 	if err != nil {
 		uc.log.Warn("抽奖失败 回滚资产失败", zap.Any("req", req), zap.Error(err))
 		return err
 	}
-	if record == nil {
-		return nil //无需回滚
+	if record != nil { //已扣除资产，需要退还
+		// 按资产记录实际扣除的货币退还
+		at := new(entity.UserAsset)
+		at.UserID = req.UserId
+		at.Gold = -record.Gold
+		at.Stone = -record.Stone
+		at.Crystal = -record.Crystal
+		// 2. 更新用户资产数据
+		err = uc.assetUc.UpdateAsset(ctx, at, req.RequestId+"0", req.RequestTime)
+		if err != nil {
+			if !strings.Contains(err.Error(), "Duplicate entry ") {
+				// 失败则等待下次重试
+				uc.log.Warn("抽奖失败 回滚资产失败", zap.Any("req", req), zap.Error(err))
+				return err
+			}
+			err = nil // 已经退还过
+		}
 	}
-	at := new(entity.UserAsset)
-	at.UserID = req.UserId
-	at.Stone = req.PrizesData.Amount
-	req.RequestId = req.RequestId + "0"
-	// 2. 更新用户资产数据
-	err = uc.assetUc.UpdateAsset(context.Background(), at, req.RequestId+"0", req.RequestTime) // 假设每次抽奖扣除用户资产
+
+	// 读取物品支付记录，已扣除抽奖券时退还
+	var items map[int64]int64
+	items, err = uc.assetUc.GetItemRecord(ctx, req.UserId, itemPayRequestId(req.RequestId))
 	if err != nil {
-		if strings.Contains(err.Error(), "Duplicate entry ") {
-			return nil
+		uc.log.Warn("抽奖失败 回滚物品失败", zap.Any("req", req), zap.Error(err))
+		return err
+	}
+	if len(items) > 0 {
+		for id, num := range items {
+			items[id] = -num
 		}
-		// 失败则等待下次重试
-		uc.log.Warn("抽奖失败 回滚资产失败", zap.Any("req", req), zap.Error(err))
+		err = uc.assetUc.UpdateItems(ctx, req.UserId, items, itemPayRequestId(req.RequestId)+"0", req.RequestTime)
+		if err != nil {
+			if !strings.Contains(err.Error(), "Duplicate entry ") {
+				uc.log.Warn("抽奖失败 回滚物品失败", zap.Any("req", req), zap.Error(err))
+				return err
+			}
+			err = nil // 已经退还过
+		}
+	}
+
+	// 撤销占用的免费抽奖
+	err = uc.undoFree(ctx, req)
+	if err != nil {
+		uc.log.Warn("抽奖失败 回滚免费抽奖失败", zap.Any("req", req), zap.Error(err))
+		return err
+	}
+
+	// 3. 撤销抽数限制
+	err = uc.undoLimit(ctx, req)
+	if err != nil {
+		uc.log.Warn("抽奖失败 回滚抽数限制失败", zap.Any("req", req), zap.Error(err))
+		return err
+	}
+
+	// 4. 归还限量奖品库存
+	err = uc.returnStock(ctx, req.PrizesData)
+	if err != nil {
+		uc.log.Warn("抽奖失败 回滚奖品库存失败", zap.Any("req", req), zap.Error(err))
+		return err
+	}
+
+	// 5. 恢复抽奖前的用户状态
+	err = uc.restoreDrawState(ctx, req)
+	if err != nil {
+		uc.log.Warn("抽奖失败 回滚抽奖状态失败", zap.Any("req", req), zap.Error(err))
 		return err
 	}
 	// todo 发个邮件，通知用户抽奖失败，已返回资产
 	return nil
 }
+
+// restoreDrawState 将用户状态恢复为抽奖前的状态，状态已被之后的抽奖更新或未保存时不处理
+func (uc *LotteryUc) restoreDrawState(ctx context.Context, req *dto.DrawReq) error {
+	data := req.PrizesData
+	if data.State == nil || data.Fair == nil {
+		return nil
+	}
+	state, err := uc.stateCache.Get(ctx, req.ActivityId, req.UserId)
+	if err != nil {
+		return err
+	}
+	if state == nil || state.DrawTotal != data.State.DrawTotal {
+		return nil
+	}
+	return uc.stateCache.Set(ctx, req.ActivityId, req.UserId, drawStateBefore(data.Fair))
+}
+
+// drawStateBefore 由抽奖时记录的参数还原抽奖前的用户状态
+func drawStateBefore(fair *dto.FairData) *dto.DrawState {
+	return &dto.DrawState{
+		Pity:              fair.Pity,
+		DrawTotal:         fair.Nonce,
+		FeaturedGuarantee: fair.FeaturedGuarantee,
+		Box:               fair.Box.Clone(),
+		Step:              fair.Step,
+	}
+}
@@ -0,0 +1,261 @@
+package lottery_uc
+
+import (
+	"context"
+	"fmt"
+	"github.com/linchengzhi/lottery/Infra/logger"
+	"github.com/linchengzhi/lottery/domain/dto"
+	"github.com/linchengzhi/lottery/domain/entity"
+	"github.com/linchengzhi/lottery/domain/types"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAssetUc 内存中的用户资产，读取物品后短暂等待，放大并发发奖时的竞争
+type fakeAssetUc struct {
+	mu      sync.Mutex
+	gold    int64
+	items   map[int64]int64
+	records map[string]map[int64]int64
+}
+
+func newFakeAssetUc() *fakeAssetUc {
+	return &fakeAssetUc{items: make(map[int64]int64), records: make(map[string]map[int64]int64)}
+}
+
+func (f *fakeAssetUc) CreateAsset(ctx context.Context, userId int64) (*entity.UserAsset, error) {
+	return &entity.UserAsset{UserID: userId}, nil
+}
+
+func (f *fakeAssetUc) GetAsset(ctx context.Context, userID int64) (*entity.UserAsset, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &entity.UserAsset{UserID: userID, Gold: f.gold}, nil
+}
+
+func (f *fakeAssetUc) GetAssetRecord(ctx context.Context, userId int64, requestId string) (*entity.UserAssetRecord, error) {
+	return nil, nil
+}
+
+func (f *fakeAssetUc) GetItemRecord(ctx context.Context, userId int64, requestId string) (map[int64]int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.records[requestId], nil
+}
+
+func (f *fakeAssetUc) ListItem(ctx context.Context, userID int64) (map[int64]int64, error) {
+	f.mu.Lock()
+	items := make(map[int64]int64, len(f.items))
+	for id, num := range f.items {
+		items[id] = num
+	}
+	f.mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	return items, nil
+}
+
+func (f *fakeAssetUc) UpdateAsset(ctx context.Context, asset *entity.UserAsset, requestId string, requestTime time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.gold += asset.Gold
+	return nil
+}
+
+func (f *fakeAssetUc) UpdateItems(ctx context.Context, userId int64, items map[int64]int64, requestId string, requestTime time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.records[requestId]; ok {
+		return fmt.Errorf("Duplicate entry '%s'", requestId)
+	}
+	for id, num := range items {
+		f.items[id] += num
+	}
+	f.records[requestId] = items
+	return nil
+}
+
+// fakeStateRd 内存中的用户状态和锁
+type fakeStateRd struct {
+	mu     sync.Mutex
+	states map[string]*dto.DrawState
+	locks  map[string]string
+	seq    int
+}
+
+func newFakeStateRd() *fakeStateRd {
+	return &fakeStateRd{states: make(map[string]*dto.DrawState), locks: make(map[string]string)}
+}
+
+func (f *fakeStateRd) Get(ctx context.Context, activityId, userId int64) (*dto.DrawState, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.states[fmt.Sprintf("%d:%d", activityId, userId)], nil
+}
+
+func (f *fakeStateRd) Set(ctx context.Context, activityId, userId int64, state *dto.DrawState) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.states[fmt.Sprintf("%d:%d", activityId, userId)] = state
+	return nil
+}
+
+func (f *fakeStateRd) lock(key string) (string, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.locks[key]; ok {
+		return "", false, nil
+	}
+	f.seq++
+	token := fmt.Sprintf("%d", f.seq)
+	f.locks[key] = token
+	return token, true, nil
+}
+
+func (f *fakeStateRd) unlock(key, token string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.locks[key] == token {
+		delete(f.locks, key)
+	}
+	return nil
+}
+
+func (f *fakeStateRd) Lock(ctx context.Context, activityId, userId int64) (string, bool, error) {
+	return f.lock(fmt.Sprintf("draw:%d:%d", activityId, userId))
+}
+
+func (f *fakeStateRd) Unlock(ctx context.Context, activityId, userId int64, token string) error {
+	return f.unlock(fmt.Sprintf("draw:%d:%d", activityId, userId), token)
+}
+
+func (f *fakeStateRd) LockAward(ctx context.Context, userId int64) (string, bool, error) {
+	return f.lock(fmt.Sprintf("award:%d", userId))
+}
+
+func (f *fakeStateRd) UnlockAward(ctx context.Context, userId int64, token string) error {
+	return f.unlock(fmt.Sprintf("award:%d", userId), token)
+}
+
+// fakeStockRd 内存中的限量奖品库存，扣减规则与 decrStockScript 相同
+type fakeStockRd struct {
+	stock map[int64]int64
+}
+
+func (f *fakeStockRd) Get(ctx context.Context, activityId int64) (map[int64]int64, error) {
+	return f.stock, nil
+}
+
+func (f *fakeStockRd) DecrBy(ctx context.Context, activityId int64, data map[int64]int64) (map[int64]int64, error) {
+	result := make(map[int64]int64)
+	for id, num := range data {
+		take := f.stock[id]
+		if take > num {
+			take = num
+		}
+		if take < 0 {
+			take = 0
+		}
+		f.stock[id] -= take
+		result[id] = take
+	}
+	return result, nil
+}
+
+func (f *fakeStockRd) IncrBy(ctx context.Context, activityId int64, data map[int64]int64) error {
+	for id, num := range data {
+		f.stock[id] += num
+	}
+	return nil
+}
+
+func (f *fakeStockRd) Sync(ctx context.Context, activityId int64, data map[int64]int64, expireAt time.Time) error {
+	return nil
+}
+
+func TestLotteryUc_DeductStock(t *testing.T) {
+	l, _ := logger.New(nil)
+	ctx := context.Background()
+	conf := dto.LotteryConf{ActivityId: 1, Price: 100, Fallback: dto.Item{Id: 900, Num: 5}}
+	conf.StarLevels = []*dto.StarLevel{
+		{Level: 1, Weight: 90, Prizes: []*dto.Prize{{Id: 1, Num: 1, Weight: 1}}},
+		{Level: 2, Weight: 10, Prizes: []*dto.Prize{{Id: 91, Num: 1, Weight: 1, Stock: 10}, {Id: 92, Num: 2, Weight: 1, Stock: 10}}},
+	}
+	puc, err := NewPrizePoolUc(l, conf)
+	assert.Nil(t, err)
+	stockRd := &fakeStockRd{}
+	uc := &LotteryUc{log: l, stockRd: stockRd}
+	prizes := func(ids ...int64) *dto.PrizeData {
+		data := &dto.PrizeData{ActivityId: 1}
+		for _, id := range ids {
+			data.Prizes = append(data.Prizes, &dto.Item{Id: id, Num: 1})
+		}
+		return data
+	}
+	ids := func(data *dto.PrizeData) []int64 {
+		var list []int64
+		for _, item := range data.Prizes {
+			list = append(list, item.Id)
+		}
+		return list
+	}
+
+	// 库存足够时全部扣减，不替换
+	stockRd.stock = map[int64]int64{91: 10, 92: 10}
+	data := prizes(1, 91, 92, 1)
+	assert.Nil(t, uc.deductStock(ctx, puc, data))
+	assert.Equal(t, []int64{1, 91, 92, 1}, ids(data))
+	assert.Equal(t, map[int64]int64{91: 1, 92: 1}, data.Stock)
+
+	// 库存为0时全部替换为替代奖品，不扣减
+	stockRd.stock = map[int64]int64{91: 0, 92: 0}
+	data = prizes(91, 1, 92)
+	assert.Nil(t, uc.deductStock(ctx, puc, data))
+	assert.Equal(t, []int64{900, 1, 900}, ids(data))
+	assert.Equal(t, int64(5), data.Prizes[0].Num)
+	assert.Equal(t, map[int64]int64{91: 0, 92: 0}, data.Stock)
+
+	// 同一奖品抽中多个且库存不足时，保留前面的，从后往前替换
+	stockRd.stock = map[int64]int64{91: 2, 92: 1}
+	data = prizes(91, 92, 91, 1, 91, 92)
+	assert.Nil(t, uc.deductStock(ctx, puc, data))
+	assert.Equal(t, []int64{91, 92, 91, 1, 900, 900}, ids(data))
+	assert.Equal(t, map[int64]int64{91: 2, 92: 1}, data.Stock)
+	assert.Equal(t, map[int64]int64{91: 0, 92: 0}, stockRd.stock)
+
+	// 回滚时归还实际扣减的库存
+	assert.Nil(t, uc.returnStock(ctx, data))
+	assert.Equal(t, map[int64]int64{91: 2, 92: 1}, stockRd.stock)
+}
+
+func TestLotteryUc_GrantAwardConcurrent(t *testing.T) {
+	l, _ := logger.New(nil)
+	asset := newFakeAssetUc()
+	uc := &LotteryUc{log: l, stateCache: newFakeStateRd(), assetUc: asset}
+
+	// 同一用户的两次抽奖都抽中唯一奖品，并发发奖时只保留1个，另一个转换为金币
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = uc.grantAward(context.Background(), &dto.AwardStream{
+				RequestId:   fmt.Sprintf("req%d", i),
+				RequestTime: time.Now(),
+				PrizeData: &dto.PrizeData{
+					ActivityId: 1,
+					UserId:     1,
+					Prizes:     []*dto.Item{{Id: 501, Num: 1}},
+					Duplicate:  map[int64]*dto.DuplicateConf{501: {Currency: types.CurrencyGold, Num: 100}},
+				},
+			})
+		}(i)
+	}
+	wg.Wait()
+	assert.Nil(t, errs[0])
+	assert.Nil(t, errs[1])
+	assert.Equal(t, int64(1), asset.items[501])
+	assert.Equal(t, int64(100), asset.gold)
+}
@@ -0,0 +1,177 @@
+package lottery_uc
+
+import (
+	"context"
+	"github.com/bytedance/sonic"
+	"github.com/linchengzhi/lottery/domain/cerror"
+	"github.com/linchengzhi/lottery/domain/dto"
+	"github.com/linchengzhi/lottery/domain/entity"
+)
+
+// Odds 获取活动的概率公示，可指定版本或时间查询当时生效的概率，都不指定时为当前奖池
+func (uc *LotteryUc) Odds(ctx context.Context, req *dto.OddsReq) (*dto.OddsResp, error) {
+	var version *entity.LotteryPoolVersion
+	var err error
+	switch {
+	case req.Version > 0:
+		version, err = uc.versionRepo.Get(ctx, req.ActivityId, req.Version)
+	case !req.At.IsZero():
+		version, err = uc.versionRepo.GetAt(ctx, req.ActivityId, req.At)
+	default:
+		puc, err := uc.getPrizePool(ctx, req.ActivityId)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := poolOdds(ctx, puc, req)
+		if err != nil {
+			return nil, err
+		}
+		// 当前奖池的生效时间取版本的创建时间
+		if version, err = uc.versionRepo.Get(ctx, req.ActivityId, resp.Version); err == nil && version != nil {
+			resp.EffectiveAt = version.CreatedAt
+		}
+		return resp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if version == nil {
+		return nil, cerror.ErrLotteryNoAct
+	}
+
+	var conf dto.LotteryConf
+	if err = sonic.Unmarshal([]byte(version.Conf), &conf); err != nil {
+		return nil, err
+	}
+	conf.Version = version.Version
+	puc, err := NewPrizePoolUc(uc.log, conf)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := poolOdds(ctx, puc, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.EffectiveAt = version.CreatedAt
+	return resp, nil
+}
+
+// poolOdds 获取分群、阶梯或实验分组奖池的概率公示，并列出活动的全部分群、阶梯和实验分组
+func poolOdds(ctx context.Context, puc IPrizePoolUc, req *dto.OddsReq) (*dto.OddsResp, error) {
+	spuc, err := puc.getSegmentPool(ctx, req.Segment)
+	if err != nil {
+		return nil, err
+	}
+	if req.Step > 0 {
+		if spuc, err = puc.getStepPool(ctx, req.Step-1); err != nil {
+			return nil, err
+		}
+	}
+	if req.Variant != "" {
+		if spuc, err = puc.getVariantPool(ctx, req.Variant); err != nil {
+			return nil, err
+		}
+	}
+	resp := spuc.Odds(ctx)
+	resp.Segments = puc.getSegments(ctx)
+	for _, v := range puc.getVariants(ctx) {
+		resp.Variants = append(resp.Variants, v.Id)
+	}
+	for i, step := range puc.getSteps(ctx) {
+		stepPuc, err := puc.getStepPool(ctx, i)
+		if err != nil {
+			return nil, err
+		}
+		resp.Steps = append(resp.Steps, &dto.StepOdds{Step: i + 1, DrawNum: step.DrawNum, Pricing: stepPuc.getPricing(ctx), MinLevel: step.MinLevel})
+	}
+	return resp, nil
+}
+
+// Odds 根据奖池权重计算单抽概率和计入保底后的期望
+func (p *PrizePoolUc) Odds(ctx context.Context) *dto.OddsResp {
+	resp := &dto.OddsResp{
+		ActivityId: p.activityId,
+		Version:    p.version,
+		Segment:    p.segment,
+		Step:       p.step,
+		Variant:    p.variant,
+	}
+	if p.box != nil {
+		return p.boxOdds(resp)
+	}
+	resp.Levels = make([]*dto.LevelOdds, 0, len(p.pool.Prizes))
+	featuredRate := float64(p.featuredRate) / 100
+	for i, level := range p.pool.Prizes {
+		levelRate := float64(level.Weight) / float64(p.levelAlias.total)
+		lo := &dto.LevelOdds{Level: level.Level, Rate: levelRate}
+		for _, prize := range level.Prizes {
+			po := &dto.PrizeOdds{Id: prize.Id, Num: prize.Num, Stock: prize.Stock}
+			switch {
+			case level == p.topLevel && p.featured != nil && p.isFeatured(prize):
+				// 未触发UP保底时，抽中最高星级后按UP概率选择UP奖品
+				rate := featuredRate
+				if p.standard == nil {
+					rate = 1
+				}
+				po.Featured = true
+				po.Rate = levelRate * rate * float64(prize.Weight) / float64(p.featured.alias.total)
+			case level == p.topLevel && p.featured != nil:
+				po.Rate = levelRate * (1 - featuredRate) * float64(prize.Weight) / float64(p.standard.alias.total)
+			default:
+				po.Rate = levelRate * float64(prize.Weight) / float64(p.prizeAlias[i].total)
+			}
+			lo.Prizes = append(lo.Prizes, po)
+		}
+		resp.Levels = append(resp.Levels, lo)
+	}
+	resp.Pity = p.pityOdds()
+	return resp
+}
+
+func (p *PrizePoolUc) isFeatured(prize *dto.Prize) bool {
+	for _, v := range p.featured.prizes {
+		if v == prize {
+			return true
+		}
+	}
+	return false
+}
+
+// pityOdds 计算计入软保底和硬保底后，平均多少抽出最高星级和UP奖品，不计入多连保底
+func (p *PrizePoolUc) pityOdds() dto.PityOdds {
+	odds := dto.PityOdds{Hard: p.pity.Hard, Soft: p.pity.Soft}
+	topWeight := p.topLevel.Weight
+	total := p.levelAlias.total
+	if topWeight == 0 && p.pity.Soft <= 0 && p.pity.Hard <= 0 {
+		return odds // 无法抽中最高星级
+	}
+
+	var expected float64
+	if p.pity.Hard <= 0 && p.pity.Soft <= 0 {
+		// 没有保底时每抽概率相同，期望抽数为几何分布的期望
+		expected = float64(total) / float64(topWeight)
+	} else {
+		// E[抽数] = sum P(前k-1抽未出最高星级)，第k抽出最高星级的概率由保底计数k决定
+		survive := 1.0
+		for k := int64(1); k <= 10000000 && survive > 1e-12; k++ {
+			expected += survive
+			rate := 1.0
+			if p.pity.Hard <= 0 || k < p.pity.Hard {
+				bonus := p.softPityBonus(k)
+				rate = float64(topWeight+bonus) / float64(total+bonus)
+			}
+			survive *= 1 - rate
+		}
+	}
+	odds.ExpectedDraws = expected
+	odds.TopRate = 1 / expected
+
+	// 每次抽中最高星级时UP的概率为r，歪了之后下次必为UP，平均 2-r 次最高星级得到一个UP
+	odds.FeaturedShare, odds.ExpectedFeaturedDraws = 1, expected
+	if p.featured != nil && p.standard != nil {
+		r := float64(p.featuredRate) / 100
+		odds.FeaturedShare = 1 / (2 - r)
+		odds.ExpectedFeaturedDraws = expected * (2 - r)
+	}
+	return odds
+}
@@ -4,105 +4,632 @@ import (
 	"context"
 	"github.com/linchengzhi/lottery/domain/cerror"
 	"github.com/linchengzhi/lottery/domain/dto"
+	"github.com/linchengzhi/lottery/domain/types"
 	"go.uber.org/zap"
 	"math/rand"
 	"time"
 )
 
 type IPrizePoolUc interface {
-	// 随机获取奖池中drawNum个奖品
-	RandomPrizes(ctx context.Context, userId, drawNum int64) (*dto.PrizeData, error)
-	//获取单抽加个
-	getPrice(ctx context.Context) int64
+	// 随机获取奖池中drawNum个奖品，state为用户抽奖状态，抽奖后会被更新；r为nil时使用随机种子
+	RandomPrizes(ctx context.Context, userId, drawNum int64, state *dto.DrawState, r *rand.Rand) (*dto.PrizeData, error)
+	// 计算奖池的概率公示
+	Odds(ctx context.Context) *dto.OddsResp
+	// 获取价格表
+	getPricing(ctx context.Context) []dto.PriceConf
+	// 获取限量奖品的总库存
+	getStock(ctx context.Context) map[int64]int64
+	// 获取限量奖品库存不足时的替代奖品
+	getFallback(ctx context.Context) *dto.Item
+	// 获取奖品中唯一奖品的重复转换规则
+	getDuplicate(ctx context.Context, prizes []*dto.Item) map[int64]*dto.DuplicateConf
+	// 获取奖品中组合奖品的内容
+	getContent(ctx context.Context, prizes []*dto.Item) map[int64]*dto.PrizeContent
+	// 获取活动的全部分群名称
+	getSegments(ctx context.Context) []string
+	// 获取用户在now时刻匹配的分群奖池，未匹配时返回默认奖池
+	matchSegment(ctx context.Context, attr *dto.UserAttr, now time.Time) IPrizePoolUc
+	// 按名称获取分群奖池，名称为空时返回默认奖池
+	getSegmentPool(ctx context.Context, name string) (IPrizePoolUc, error)
+	// 活动的全部实验分组
+	getVariants(ctx context.Context) []dto.VariantConf
+	// 按用户ID获取用户所在的实验分组奖池，没有分组时返回活动奖池
+	matchVariant(ctx context.Context, userId int64) IPrizePoolUc
+	// 按ID获取实验分组奖池，ID为空时返回活动奖池
+	getVariantPool(ctx context.Context, id string) (IPrizePoolUc, error)
+	// 阶梯模式的全部阶梯，非阶梯模式为空
+	getSteps(ctx context.Context) []dto.StepConf
+	// 获取第index个阶梯的奖池，从0开始
+	getStepPool(ctx context.Context, index int) (IPrizePoolUc, error)
+	// 获取now所在的免费抽奖周期和保存时间，未开启免费抽奖时ok为false
+	getFree(ctx context.Context, now time.Time) (period string, ttl time.Duration, ok bool)
+	// 获取兑换积分配置
+	getSpark(ctx context.Context) dto.SparkConf
+	// 箱子模式创建第round箱，非箱子模式返回 nil
+	getNewBox(ctx context.Context, round int64) *dto.BoxState
+	// 箱子模式用户箱子中剩余的奖品
+	getBoxResp(ctx context.Context, userId int64, box *dto.BoxState) *dto.BoxResp
+	// 获取活动ID
+	getActivityId(ctx context.Context) int64
+	// 获取活动在now时刻的状态
+	getState(ctx context.Context, now time.Time) string
+	// 获取活动结束时间，为零表示不结束
+	getEndTime(ctx context.Context) time.Time
+	// 获取奖池版本
+	getVersion(ctx context.Context) int64
+	// 设置奖池版本，仅在奖池生效前调用
+	setVersion(version int64)
+	// 获取抽数限制
+	getLimit(ctx context.Context) dto.LimitConf
+	// 获取now在活动时区的日期，用于每日抽数限制
+	getLimitDay(ctx context.Context, now time.Time) string
 }
 
 type PrizePoolUc struct {
 	activityId int64
-	price      int64
-	pool       *dto.PrizePool // 奖池
-	log        *zap.Logger
+	version    int64
+	segment    string         // 分群名称，默认奖池为空
+	segments   []*poolSegment // 分群奖池，按配置顺序匹配
+	step       int            // 阶梯，从1开始，非阶梯奖池为0
+	steps      []*poolStep    // 阶梯模式的阶梯奖池，按顺序循环
+	variant    string         // 实验分组ID，活动奖池为空
+	variants   []*poolVariant // 实验分组奖池，按用户ID哈希分配
+	box        *dto.BoxConf   // 箱子模式的配置，非箱子模式为 nil
+	boxTotal   int64          // 一箱的奖品总个数
+	startTime  time.Time
+	endTime    time.Time
+	paused     bool
+	pricing    []dto.PriceConf // 价格表
+	pool       *dto.PrizePool  // 奖池
+	topLevel   *dto.StarLevel  // 最高星级
+	pity       dto.PityConf    // 保底配置
+	batch      dto.BatchGuaranteeConf
+	stock      map[int64]int64              // 限量奖品总库存
+	fallback   dto.Item                     // 库存不足时的替代奖品
+	duplicate  map[int64]*dto.DuplicateConf // 唯一奖品的重复转换规则
+	content    map[int64]*dto.PrizeContent  // 组合奖品的内容
+	limit      dto.LimitConf                // 抽数限制
+	spark      dto.SparkConf                // 兑换积分
+	free       dto.FreeConf                 // 免费抽奖
+	freeLoc    *time.Location               // 每日免费抽奖重置使用的时区
+	location   *time.Location               // 每日重置使用的时区
+
+	levelAlias  *aliasTable   // 星级的别名表，与pool.Prizes下标对应
+	prizeAlias  []*aliasTable // 每个星级内奖品的别名表，与pool.Prizes下标对应
+	topIndex    int           // 最高星级在pool.Prizes中的下标
+	floorLevels []int         // 多连保底可选的星级下标
+	floorAlias  *aliasTable   // 多连保底星级的别名表，与floorLevels下标对应
+
+	featuredRate int64        // 抽中最高星级时获得UP奖品的概率(百分比)
+	featured     *prizeWeight // 最高星级中的UP奖品
+	standard     *prizeWeight // 最高星级中的常驻奖品
+	log          *zap.Logger
+}
+
+// poolSegment 分群条件及其奖池
+type poolSegment struct {
+	conf dto.SegmentConf
+	pool *PrizePoolUc
+}
+
+// poolStep 阶梯配置及其奖池
+type poolStep struct {
+	conf dto.StepConf
+	pool *PrizePoolUc
+}
+
+// prizeWeight 奖品及其别名表
+type prizeWeight struct {
+	prizes []*dto.Prize
+	alias  *aliasTable
+}
+
+func newPrizeWeight(prizes []*dto.Prize) *prizeWeight {
+	weights := make([]int64, len(prizes))
+	for i, prize := range prizes {
+		weights[i] = prize.Weight
+	}
+	return &prizeWeight{prizes: prizes, alias: newAliasTable(weights)}
+}
+
+func (pw *prizeWeight) random(r *rand.Rand) *dto.Prize {
+	return pw.prizes[pw.alias.random(r)]
 }
 
 // NewPrizePoolUc 创建一个新的 PrizePoolUc 实例
 func NewPrizePoolUc(log *zap.Logger, conf dto.LotteryConf) (IPrizePoolUc, error) {
 	p := new(PrizePoolUc)
 	p.activityId = conf.ActivityId
-	p.price = conf.Price
+	p.version = conf.Version
+	p.startTime = conf.StartTime
+	p.endTime = conf.EndTime
+	p.paused = conf.State == types.ActivityStatePaused
+	p.pricing = conf.PriceTable()
+	p.pity = conf.Pity
+	p.batch = conf.BatchGuarantee
+	p.stock = make(map[int64]int64)
+	p.duplicate = make(map[int64]*dto.DuplicateConf)
+	p.content = make(map[int64]*dto.PrizeContent)
+	p.fallback = conf.Fallback
+	p.limit = conf.Limit
+	p.spark = conf.Spark
+	p.location = time.Local
+	if conf.Limit.Timezone != "" {
+		loc, err := time.LoadLocation(conf.Limit.Timezone)
+		if err != nil {
+			return nil, cerror.ErrLotteryConfig.WithErr(err)
+		}
+		p.location = loc
+	}
+	p.free = conf.Free
+	p.freeLoc = p.location
+	if conf.Free.Timezone != "" {
+		loc, err := time.LoadLocation(conf.Free.Timezone)
+		if err != nil {
+			return nil, cerror.ErrLotteryConfig.WithErr(err)
+		}
+		p.freeLoc = loc
+	}
+	p.featuredRate = conf.FeaturedRate
+	if p.featuredRate <= 0 {
+		p.featuredRate = 50
+	}
 	p.log = log
+	if conf.Mode == types.ActivityModeBox {
+		if err := p.createBox(conf.Box); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
 	err := p.createPool(conf.StarLevels)
 	if err != nil {
 		return nil, err
 	}
+	err = p.createSegments(conf)
+	if err != nil {
+		return nil, err
+	}
+	err = p.createVariants(conf)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Mode == types.ActivityModeStepUp {
+		if err = p.createSteps(conf); err != nil {
+			return nil, err
+		}
+	}
 	return p, nil
 }
 
-func (p *PrizePoolUc) getPrice(ctx context.Context) int64 {
-	return p.price
+// createSteps 创建阶梯奖池，阶梯替换价格和星级奖品，阶梯保底复用多连保底，共用限量奖品库存
+func (p *PrizePoolUc) createSteps(conf dto.LotteryConf) error {
+	if len(conf.Steps) == 0 {
+		return cerror.ErrLotteryConfig
+	}
+	for i, step := range conf.Steps {
+		stepConf := conf
+		stepConf.Mode = ""
+		stepConf.Steps = nil
+		stepConf.Segments = nil
+		if len(step.Pricing) > 0 {
+			stepConf.Pricing = step.Pricing
+		}
+		if len(step.StarLevels) > 0 {
+			stepConf.StarLevels = step.StarLevels
+		}
+		stepConf.BatchGuarantee = dto.BatchGuaranteeConf{}
+		if step.MinLevel > 0 {
+			stepConf.BatchGuarantee = dto.BatchGuaranteeConf{BatchSize: step.DrawNum, MinLevel: step.MinLevel}
+		}
+		puc, err := NewPrizePoolUc(p.log, stepConf)
+		if err != nil {
+			return err
+		}
+		pool := puc.(*PrizePoolUc)
+		pool.step = i + 1
+		for id, stock := range pool.stock {
+			p.stock[id] = stock
+		}
+		p.steps = append(p.steps, &poolStep{conf: step, pool: pool})
+	}
+	return nil
+}
+
+// createSegments 创建分群奖池，分群只替换星级奖品，共用限量奖品库存
+func (p *PrizePoolUc) createSegments(conf dto.LotteryConf) error {
+	for _, seg := range conf.Segments {
+		segConf := conf
+		segConf.StarLevels = seg.StarLevels
+		segConf.Segments = nil
+		puc, err := NewPrizePoolUc(p.log, segConf)
+		if err != nil {
+			return err
+		}
+		pool := puc.(*PrizePoolUc)
+		pool.segment = seg.Name
+		for id, stock := range pool.stock {
+			p.stock[id] = stock
+		}
+		p.segments = append(p.segments, &poolSegment{conf: seg, pool: pool})
+	}
+	return nil
+}
+
+func (p *PrizePoolUc) getPricing(ctx context.Context) []dto.PriceConf {
+	return p.pricing
+}
+
+func (p *PrizePoolUc) getFree(ctx context.Context, now time.Time) (string, time.Duration, bool) {
+	switch {
+	case p.free.Daily:
+		return now.In(p.freeLoc).Format("20060102"), time.Duration(48) * time.Hour, true
+	case p.free.Hours > 0:
+		return "", time.Duration(p.free.Hours) * time.Hour, true
+	}
+	return "", 0, false
+}
+
+func (p *PrizePoolUc) getSpark(ctx context.Context) dto.SparkConf {
+	return p.spark
+}
+
+func (p *PrizePoolUc) getStock(ctx context.Context) map[int64]int64 {
+	return p.stock
+}
+
+func (p *PrizePoolUc) getFallback(ctx context.Context) *dto.Item {
+	return &dto.Item{Id: p.fallback.Id, Num: p.fallback.Num}
+}
+
+func (p *PrizePoolUc) getDuplicate(ctx context.Context, prizes []*dto.Item) map[int64]*dto.DuplicateConf {
+	var result map[int64]*dto.DuplicateConf
+	for _, prize := range prizes {
+		if rule, ok := p.duplicate[prize.Id]; ok {
+			if result == nil {
+				result = make(map[int64]*dto.DuplicateConf)
+			}
+			result[prize.Id] = rule
+		}
+	}
+	return result
+}
+
+func (p *PrizePoolUc) getContent(ctx context.Context, prizes []*dto.Item) map[int64]*dto.PrizeContent {
+	var result map[int64]*dto.PrizeContent
+	for _, prize := range prizes {
+		if content, ok := p.content[prize.Id]; ok {
+			if result == nil {
+				result = make(map[int64]*dto.PrizeContent)
+			}
+			result[prize.Id] = content
+		}
+	}
+	return result
+}
+
+func (p *PrizePoolUc) getActivityId(ctx context.Context) int64 {
+	return p.activityId
+}
+
+func (p *PrizePoolUc) getEndTime(ctx context.Context) time.Time {
+	return p.endTime
+}
+
+// getState 根据时间窗口计算活动状态，结束优先于暂停
+func (p *PrizePoolUc) getState(ctx context.Context, now time.Time) string {
+	if !p.endTime.IsZero() && !now.Before(p.endTime) {
+		return types.ActivityStateEnded
+	}
+	if !p.startTime.IsZero() && now.Before(p.startTime) {
+		return types.ActivityStateScheduled
+	}
+	if p.paused {
+		return types.ActivityStatePaused
+	}
+	return types.ActivityStateRunning
+}
+
+func (p *PrizePoolUc) getLimit(ctx context.Context) dto.LimitConf {
+	return p.limit
+}
+
+func (p *PrizePoolUc) getLimitDay(ctx context.Context, now time.Time) string {
+	return now.In(p.location).Format("20060102")
+}
+
+func (p *PrizePoolUc) getVersion(ctx context.Context) int64 {
+	return p.version
+}
+
+func (p *PrizePoolUc) setVersion(version int64) {
+	p.version = version
+	for _, seg := range p.segments {
+		seg.pool.version = version
+	}
+	for _, step := range p.steps {
+		step.pool.version = version
+	}
+	for _, v := range p.variants {
+		v.pool.version = version
+	}
+}
+
+func (p *PrizePoolUc) getSteps(ctx context.Context) []dto.StepConf {
+	steps := make([]dto.StepConf, 0, len(p.steps))
+	for _, step := range p.steps {
+		steps = append(steps, step.conf)
+	}
+	return steps
+}
+
+func (p *PrizePoolUc) getStepPool(ctx context.Context, index int) (IPrizePoolUc, error) {
+	if index < 0 || index >= len(p.steps) {
+		return nil, cerror.ErrNoStep
+	}
+	return p.steps[index].pool, nil
+}
+
+func (p *PrizePoolUc) getNewBox(ctx context.Context, round int64) *dto.BoxState {
+	if p.box == nil {
+		return nil
+	}
+	return p.newBox(round)
+}
+
+func (p *PrizePoolUc) getBoxResp(ctx context.Context, userId int64, box *dto.BoxState) *dto.BoxResp {
+	return p.boxResp(userId, box)
+}
+
+func (p *PrizePoolUc) getSegments(ctx context.Context) []string {
+	names := make([]string, 0, len(p.segments))
+	for _, seg := range p.segments {
+		names = append(names, seg.conf.Name)
+	}
+	return names
+}
+
+func (p *PrizePoolUc) matchSegment(ctx context.Context, attr *dto.UserAttr, now time.Time) IPrizePoolUc {
+	for _, seg := range p.segments {
+		if seg.conf.Match(attr, now) {
+			return seg.pool
+		}
+	}
+	return p
+}
+
+func (p *PrizePoolUc) getSegmentPool(ctx context.Context, name string) (IPrizePoolUc, error) {
+	if name == p.segment {
+		return p, nil
+	}
+	for _, seg := range p.segments {
+		if seg.conf.Name == name {
+			return seg.pool, nil
+		}
+	}
+	return nil, cerror.ErrNoSegment
 }
 
-// createPool 创建奖池
+// createPool 创建奖池，为星级和奖品预先生成别名表，不修改配置中的权重
 func (p *PrizePoolUc) createPool(starLevels []*dto.StarLevel) error {
 	if len(starLevels) == 0 {
 		return cerror.ErrLotteryConfig
 	}
-	p.pool = &dto.PrizePool{}
-	levelsCumWeight := int64(0)
-	for _, level := range starLevels {
-		levelsCumWeight += level.Weight
-		level.Weight = levelsCumWeight
+	if !p.startTime.IsZero() && !p.endTime.IsZero() && !p.startTime.Before(p.endTime) {
+		return cerror.ErrLotteryConfig
+	}
+	p.pool = &dto.PrizePool{Prizes: starLevels}
+	levelWeights := make([]int64, len(starLevels))
+	levelTotal := int64(0)
+	p.prizeAlias = make([]*aliasTable, len(starLevels))
+	for i, level := range starLevels {
+		if p.topLevel == nil || level.Level > p.topLevel.Level {
+			p.topLevel = level
+			p.topIndex = i
+		}
+		levelWeights[i] = level.Weight
+		levelTotal += level.Weight
+
+		prizeWeights := make([]int64, len(level.Prizes))
+		prizeTotal := int64(0)
+		for j, prize := range level.Prizes {
+			prizeWeights[j] = prize.Weight
+			prizeTotal += prize.Weight
+			if prize.Stock > 0 {
+				p.stock[prize.Id] = prize.Stock
+			}
+			if prize.Unique && prize.Duplicate != nil {
+				p.duplicate[prize.Id] = prize.Duplicate
+			}
+			if prize.Content != nil {
+				p.content[prize.Id] = prize.Content
+			}
+		}
+		if prizeTotal <= 0 {
+			return cerror.ErrLotteryConfig
+		}
+		p.prizeAlias[i] = newAliasTable(prizeWeights)
+	}
+	if levelTotal <= 0 {
+		return cerror.ErrLotteryConfig
+	}
+	p.levelAlias = newAliasTable(levelWeights)
 
-		prizesCumWeight := int64(0)
-		for _, prize := range level.Prizes {
-			prizesCumWeight += prize.Weight
-			prize.Weight = prizesCumWeight
+	// 有限量奖品时必须配置替代奖品
+	if len(p.stock) > 0 && p.fallback.Id == 0 {
+		return cerror.ErrLotteryConfig
+	}
+	if err := p.createFloor(); err != nil {
+		return err
+	}
+	return p.createFeatured()
+}
+
+// createFloor 生成多连保底可选星级的别名表
+func (p *PrizePoolUc) createFloor() error {
+	if p.batch.BatchSize <= 0 {
+		return nil
+	}
+	floorWeights := make([]int64, 0)
+	floorTotal := int64(0)
+	for i, level := range p.pool.Prizes {
+		if level.Level >= p.batch.MinLevel {
+			p.floorLevels = append(p.floorLevels, i)
+			floorWeights = append(floorWeights, level.Weight)
+			floorTotal += level.Weight
 		}
 	}
-	p.pool.Prizes = starLevels
+	if floorTotal <= 0 {
+		return cerror.ErrLotteryConfig
+	}
+	p.floorAlias = newAliasTable(floorWeights)
+	return nil
+}
+
+// createFeatured 将最高星级的奖品拆分为UP奖品和常驻奖品
+func (p *PrizePoolUc) createFeatured() error {
+	if len(p.topLevel.Featured) == 0 {
+		return nil
+	}
+	featuredIds := make(map[int64]bool)
+	for _, id := range p.topLevel.Featured {
+		featuredIds[id] = true
+	}
+	var featured, standard []*dto.Prize
+	for _, prize := range p.topLevel.Prizes {
+		if featuredIds[prize.Id] {
+			featured = append(featured, prize)
+		} else {
+			standard = append(standard, prize)
+		}
+	}
+	if len(featured) != len(featuredIds) {
+		return cerror.ErrLotteryConfig // UP奖品必须在最高星级中
+	}
+	p.featured = newPrizeWeight(featured)
+	if len(standard) > 0 {
+		p.standard = newPrizeWeight(standard) // 全部为UP奖品时为nil
+	}
 	return nil
 }
 
 // RandomPrizes 随机获取奖池中 drawNum 个奖品
-func (p *PrizePoolUc) RandomPrizes(ctx context.Context, userId, drawNum int64) (*dto.PrizeData, error) {
+func (p *PrizePoolUc) RandomPrizes(ctx context.Context, userId, drawNum int64, state *dto.DrawState, r *rand.Rand) (*dto.PrizeData, error) {
+	if state == nil {
+		state = new(dto.DrawState)
+	}
 	items := make([]*dto.Item, drawNum)
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	batchGuarantee := int64(0)
+	batchHit := false // 当前批次是否已有奖品达到保底星级
+	topNum := int64(0)
+	if r == nil {
+		seed, err := CryptoRng{}.NewSeed()
+		if err != nil {
+			return nil, err
+		}
+		r = newChaCha8Rand(seed)
+	}
+	if p.box != nil {
+		return p.randomBox(userId, drawNum, state, r)
+	}
 	for i := int64(0); i < drawNum; i++ {
+		state.Pity++
+		state.DrawTotal++
+
 		// Step 1: 随机选择一个星级
-		starLevel := randomStarLevel(r, p.pool.Prizes)
+		// 达到硬保底时直接选择最高星级，批次最后一抽仍未达到保底星级时只在保底星级中随机
+		var levelIndex int
+		bonus := p.softPityBonus(state.Pity)
+		batchLast := p.batch.BatchSize > 0 && (i+1)%p.batch.BatchSize == 0
+		if p.pity.Hard > 0 && state.Pity >= p.pity.Hard {
+			levelIndex = p.topIndex
+		} else if batchLast && !batchHit {
+			levelIndex = p.randomFloorLevel(r, bonus)
+			batchGuarantee++
+		} else {
+			levelIndex = p.randomStarLevel(r, bonus)
+		}
+		starLevel := p.pool.Prizes[levelIndex]
+		if starLevel == p.topLevel {
+			state.Pity = 0
+			topNum++
+		}
+		if p.batch.BatchSize > 0 {
+			batchHit = batchHit || starLevel.Level >= p.batch.MinLevel
+			if batchLast {
+				batchHit = false
+			}
+		}
 
-		// Step 2: 在选中的星级中随机选择一个奖品
-		prize := randomPrizeFromLevel(r, starLevel)
+		// Step 2: 在选中的星级中随机选择一个奖品，最高星级有UP奖品时先判断是否为UP
+		var prize *dto.Prize
+		if starLevel == p.topLevel && p.featured != nil {
+			prize = p.randomFeatured(r, state)
+		} else {
+			prize = starLevel.Prizes[p.prizeAlias[levelIndex].random(r)]
+		}
 
 		item := new(dto.Item)
 		item.Id = prize.Id
 		item.Num = prize.Num
 		items[i] = item
 	}
+	if p.step > 0 {
+		state.Step++
+	}
 	data := &dto.PrizeData{
-		ActivityId: p.activityId,
-		UserId:     userId,
-		Prizes:     items,
+		ActivityId:  p.activityId,
+		PoolVersion: p.version,
+		Segment:     p.segment,
+		Step:        p.step,
+		Variant:     p.variant,
+		TopNum:      topNum,
+		UserId:      userId,
+		Prizes:      items,
+		State:       state,
+
+		BatchGuarantee: batchGuarantee,
 	}
 	return data, nil
 }
 
-// randomStarLevel 根据权重随机选择一个星级
-func randomStarLevel(r *rand.Rand, levels []*dto.StarLevel) *dto.StarLevel {
-	randVal := r.Int63n(levels[len(levels)-1].Weight)
-	for _, level := range levels {
-		if randVal < level.Weight {
-			return level
+// softPityBonus 计算软保底增加的最高星级权重，pity为包含本抽在内距上次最高星级的抽数
+func (p *PrizePoolUc) softPityBonus(pity int64) int64 {
+	if p.pity.Soft <= 0 || pity <= p.pity.Soft {
+		return 0
+	}
+	n := pity - p.pity.Soft
+	if len(p.pity.SoftSteps) > 0 {
+		if n > int64(len(p.pity.SoftSteps)) {
+			n = int64(len(p.pity.SoftSteps))
 		}
+		return p.pity.SoftSteps[n-1]
 	}
-	return nil
+	return p.pity.SoftStep * n
 }
 
-// randomPrizeFromLevel 根据权重随机选择一个星级中的奖品
-func randomPrizeFromLevel(r *rand.Rand, level *dto.StarLevel) *dto.Prize {
-	randVal := r.Int63n(level.Prizes[len(level.Prizes)-1].Weight)
-	for _, prize := range level.Prizes {
-		if randVal < prize.Weight {
-			return prize
-		}
+// randomStarLevel 根据权重随机选择一个星级的下标，bonus为本抽额外增加给最高星级的权重
+// 先以bonus/(total+bonus)的概率直接命中最高星级，否则按原始权重抽样，合并后与加权后的分布一致
+func (p *PrizePoolUc) randomStarLevel(r *rand.Rand, bonus int64) int {
+	if bonus > 0 && r.Int63n(p.levelAlias.total+bonus) < bonus {
+		return p.topIndex
 	}
-	return nil
+	return p.levelAlias.random(r)
+}
+
+// randomFloorLevel 在不低于多连保底星级的星级中根据权重随机选择一个星级的下标，最高星级必在其中
+func (p *PrizePoolUc) randomFloorLevel(r *rand.Rand, bonus int64) int {
+	if bonus > 0 && r.Int63n(p.floorAlias.total+bonus) < bonus {
+		return p.topIndex
+	}
+	return p.floorLevels[p.floorAlias.random(r)]
+}
+
+// randomFeatured 抽中最高星级时，按UP概率选择UP奖品或常驻奖品；未抽中UP后下次最高星级必为UP
+func (p *PrizePoolUc) randomFeatured(r *rand.Rand, state *dto.DrawState) *dto.Prize {
+	if state.FeaturedGuarantee || p.standard == nil || r.Int63n(100) < p.featuredRate {
+		state.FeaturedGuarantee = false
+		return p.featured.random(r)
+	}
+	state.FeaturedGuarantee = true
+	return p.standard.random(r)
 }
@@ -2,10 +2,17 @@ package lottery_uc
 
 import (
 	"context"
+	"encoding/hex"
 	"github.com/linchengzhi/lottery/Infra/logger"
+	"github.com/linchengzhi/lottery/domain/cerror"
 	"github.com/linchengzhi/lottery/domain/dto"
+	"github.com/linchengzhi/lottery/domain/entity"
+	"github.com/linchengzhi/lottery/domain/types"
 	"github.com/stretchr/testify/assert"
+	"math"
+	"math/rand"
 	"testing"
+	"time"
 )
 
 func TestPrizePoolUc_RandomAward(t *testing.T) {
@@ -40,33 +47,414 @@ func TestPrizePoolUc_RandomAward(t *testing.T) {
 
 	ctx := context.Background()
 	drawNum := int64(100)
-	awards, err := uc.RandomPrizes(ctx, 0, drawNum)
+	awards, err := uc.RandomPrizes(ctx, 0, drawNum, &dto.DrawState{}, nil)
 	assert.NoError(t, err)
 	assert.Len(t, awards.Prizes, int(drawNum))
 }
 
-//
-//func BenchmarkRandomAward(b *testing.B) {
-//	starLevels := createStarLevels()
-//	uc, err := NewPrizePoolUc(starLevels)
-//	if err != nil {
-//		b.Fatalf("Failed to create PrizePoolUc: %v", err)
-//	}
-//
-//	ctx := context.Background()
-//	drawNum := int64(100) // 每次抽取10个奖品
-//
-//	b.ResetTimer()
-//
-//	b.RunParallel(func(pb *testing.PB) {
-//		for pb.Next() {
-//			_, err = uc.RandomPrizes(ctx, drawNum)
-//			if err != nil {
-//				b.Fatalf("RandomPrizes failed: %v", err)
-//			}
-//		}
-//	})
-//}
+func TestPrizePoolUc_HardPity(t *testing.T) {
+	lotterConf := new(dto.LotteryConf)
+	lotterConf.ActivityId = 12345
+	lotterConf.Price = 100
+	lotterConf.StarLevels = []*dto.StarLevel{
+		{Level: 1, Weight: 1000000, Prizes: []*dto.Prize{{Id: 1, Num: 1, Weight: 1}}},
+		{Level: 2, Weight: 1, Prizes: []*dto.Prize{{Id: 2, Num: 1, Weight: 1}}},
+	}
+	lotterConf.Pity.Hard = 10
+
+	l, _ := logger.New(nil)
+	uc, err := NewPrizePoolUc(l, *lotterConf)
+	assert.NoError(t, err)
+
+	// 第10抽必出最高星级，之后重新计数
+	state := &dto.DrawState{}
+	awards, err := uc.RandomPrizes(context.Background(), 0, 15, state, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), awards.Prizes[9].Id)
+	assert.Equal(t, int64(5), state.Pity)
+	assert.Equal(t, int64(15), state.DrawTotal)
+
+	// 计数跨请求累计
+	state = &dto.DrawState{Pity: 8}
+	awards, err = uc.RandomPrizes(context.Background(), 0, 2, state, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), awards.Prizes[1].Id)
+	assert.Equal(t, int64(0), state.Pity)
+}
+
+func newSoftPityPool(t *testing.T, pity dto.PityConf) IPrizePoolUc {
+	lotterConf := new(dto.LotteryConf)
+	lotterConf.ActivityId = 12345
+	lotterConf.Price = 100
+	lotterConf.StarLevels = []*dto.StarLevel{
+		{Level: 1, Weight: 60, Prizes: []*dto.Prize{{Id: 1, Num: 1, Weight: 1}}},
+		{Level: 3, Weight: 10, Prizes: []*dto.Prize{{Id: 3, Num: 1, Weight: 1}}},
+		{Level: 2, Weight: 30, Prizes: []*dto.Prize{{Id: 2, Num: 1, Weight: 1}}},
+	}
+	lotterConf.Pity = pity
+
+	l, _ := logger.New(nil)
+	uc, err := NewPrizePoolUc(l, *lotterConf)
+	assert.NoError(t, err)
+	return uc
+}
+
+// topRate 以固定的保底计数单抽n次，统计最高星级(奖品3)的概率
+func topRate(t *testing.T, uc IPrizePoolUc, pity int64, n int) float64 {
+	hit := 0
+	for i := 0; i < n; i++ {
+		awards, err := uc.RandomPrizes(context.Background(), 0, 1, &dto.DrawState{Pity: pity}, nil)
+		assert.NoError(t, err)
+		if awards.Prizes[0].Id == 3 {
+			hit++
+		}
+	}
+	return float64(hit) / float64(n)
+}
+
+func TestPrizePoolUc_SoftPityLinear(t *testing.T) {
+	uc := newSoftPityPool(t, dto.PityConf{Soft: 5, SoftStep: 10})
+	n := 50000
+
+	// 未超过软保底，权重不变 10/100
+	assert.InDelta(t, 0.10, topRate(t, uc, 4, n), 0.01)
+	// 第8抽，超过软保底3抽，权重 (10+30)/(100+30)
+	assert.InDelta(t, 40.0/130.0, topRate(t, uc, 7, n), 0.01)
+	// 第15抽，权重 (10+100)/(100+100)
+	assert.InDelta(t, 110.0/200.0, topRate(t, uc, 14, n), 0.01)
+}
+
+func TestPrizePoolUc_SoftPitySteps(t *testing.T) {
+	uc := newSoftPityPool(t, dto.PityConf{Soft: 5, SoftStep: 1000, SoftSteps: []int64{20, 90}})
+	n := 50000
+
+	// 第6抽使用步进表第一项 (10+20)/(100+20)
+	assert.InDelta(t, 30.0/120.0, topRate(t, uc, 5, n), 0.01)
+	// 超出步进表长度使用最后一项 (10+90)/(100+90)
+	assert.InDelta(t, 100.0/190.0, topRate(t, uc, 20, n), 0.01)
+}
+
+func TestPrizePoolUc_SoftPityDistribution(t *testing.T) {
+	uc := newSoftPityPool(t, dto.PityConf{Soft: 5, SoftStep: 30})
+	n := 50000
+
+	// 加权后其余星级按原比例分配剩余概率 (第7抽 bonus=60, 总权重160)
+	counts := make(map[int64]int)
+	for i := 0; i < n; i++ {
+		awards, err := uc.RandomPrizes(context.Background(), 0, 1, &dto.DrawState{Pity: 6}, nil)
+		assert.NoError(t, err)
+		counts[awards.Prizes[0].Id]++
+	}
+	assert.InDelta(t, 60.0/160.0, float64(counts[1])/float64(n), 0.01)
+	assert.InDelta(t, 30.0/160.0, float64(counts[2])/float64(n), 0.01)
+	assert.InDelta(t, 70.0/160.0, float64(counts[3])/float64(n), 0.01)
+}
+
+func TestPrizePoolUc_BatchGuarantee(t *testing.T) {
+	lotterConf := new(dto.LotteryConf)
+	lotterConf.ActivityId = 12345
+	lotterConf.Price = 100
+	lotterConf.StarLevels = []*dto.StarLevel{
+		{Level: 1, Weight: 1000000, Prizes: []*dto.Prize{{Id: 1, Num: 1, Weight: 1}}},
+		{Level: 2, Weight: 1, Prizes: []*dto.Prize{{Id: 2, Num: 1, Weight: 1}}},
+		{Level: 3, Weight: 1, Prizes: []*dto.Prize{{Id: 3, Num: 1, Weight: 1}}},
+	}
+	lotterConf.BatchGuarantee = dto.BatchGuaranteeConf{BatchSize: 10, MinLevel: 2}
+
+	l, _ := logger.New(nil)
+	uc, err := NewPrizePoolUc(l, *lotterConf)
+	assert.NoError(t, err)
+
+	// 每个完整的10连最后一抽升级，不足一批的部分不保底
+	awards, err := uc.RandomPrizes(context.Background(), 0, 25, &dto.DrawState{}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), awards.BatchGuarantee)
+	assert.NotEqual(t, int64(1), awards.Prizes[9].Id)
+	assert.NotEqual(t, int64(1), awards.Prizes[19].Id)
+	for i := 20; i < 25; i++ {
+		assert.Equal(t, int64(1), awards.Prizes[i].Id)
+	}
+
+	// 单抽不触发
+	awards, err = uc.RandomPrizes(context.Background(), 0, 1, &dto.DrawState{}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), awards.BatchGuarantee)
+
+	// 保底星级不存在时配置错误
+	lotterConf.StarLevels = []*dto.StarLevel{
+		{Level: 1, Weight: 1, Prizes: []*dto.Prize{{Id: 1, Num: 1, Weight: 1}}},
+	}
+	_, err = NewPrizePoolUc(l, *lotterConf)
+	assert.Error(t, err)
+}
+
+func TestPrizePoolUc_Featured(t *testing.T) {
+	lotterConf := new(dto.LotteryConf)
+	lotterConf.ActivityId = 12345
+	lotterConf.Price = 100
+	lotterConf.StarLevels = []*dto.StarLevel{
+		{Level: 1, Weight: 50, Prizes: []*dto.Prize{{Id: 1, Num: 1, Weight: 1}}},
+		{Level: 2, Weight: 50, Featured: []int64{3}, Prizes: []*dto.Prize{
+			{Id: 3, Num: 1, Weight: 10},
+			{Id: 4, Num: 1, Weight: 10},
+			{Id: 5, Num: 1, Weight: 10},
+		}},
+	}
+	lotterConf.FeaturedRate = 50
+
+	l, _ := logger.New(nil)
+	uc, err := NewPrizePoolUc(l, *lotterConf)
+	assert.NoError(t, err)
+
+	// 歪了之后下一次最高星级必为UP
+	state := &dto.DrawState{}
+	awards, err := uc.RandomPrizes(context.Background(), 0, 10000, state, nil)
+	assert.NoError(t, err)
+	featured, lose := 0, false
+	for _, item := range awards.Prizes {
+		if item.Id == 1 {
+			continue
+		}
+		if item.Id == 3 {
+			featured++
+			lose = false
+			continue
+		}
+		assert.False(t, lose)
+		lose = true
+	}
+	assert.Equal(t, lose, state.FeaturedGuarantee)
+	// 50%UP加上歪后必UP，最高星级中UP约占2/3
+	assert.InDelta(t, 2.0/3.0, float64(featured)/5000.0, 0.05)
+
+	// UP奖品必须在最高星级中
+	lotterConf.StarLevels[1].Featured = []int64{1}
+	_, err = NewPrizePoolUc(l, *lotterConf)
+	assert.Error(t, err)
+}
+
+func TestPrizePoolUc_FairReplay(t *testing.T) {
+	lotterConf := dto.LotteryConf{ActivityId: 12345, Price: 100, StarLevels: createStarLevels()}
+	lotterConf.Pity = dto.PityConf{Hard: 20, Soft: 10, SoftStep: 5}
+	l, _ := logger.New(nil)
+	uc, err := NewPrizePoolUc(l, lotterConf)
+	assert.NoError(t, err)
+
+	rng := CryptoRng{}
+	draw := func(seed, clientSeed string, nonce int64) []*dto.Item {
+		r := rng.New(fairSeed(seed, clientSeed, 1, nonce))
+		awards, err := uc.RandomPrizes(context.Background(), 1, 10, &dto.DrawState{Pity: 5, DrawTotal: nonce}, r)
+		assert.NoError(t, err)
+		return awards.Prizes
+	}
+
+	// 相同的种子、客户端种子和序号得到相同的结果
+	b, err := rng.NewSeed()
+	assert.NoError(t, err)
+	seed := hex.EncodeToString(b)
+	assert.Len(t, hashSeed(seed), 64)
+	assert.Equal(t, draw(seed, "abc", 7), draw(seed, "abc", 7))
+	// 任一参数不同结果不同
+	assert.NotEqual(t, draw(seed, "abc", 7), draw(seed, "abc", 8))
+	assert.NotEqual(t, draw(seed, "abc", 7), draw(seed, "abd", 7))
+}
+
+func TestRng_Seeded(t *testing.T) {
+	// 相同主种子生成相同的种子序列，不同主种子不同
+	a, b, c := NewSeededRng(1), NewSeededRng(1), NewSeededRng(2)
+	for i := 0; i < 3; i++ {
+		sa, _ := a.NewSeed()
+		sb, _ := b.NewSeed()
+		sc, _ := c.NewSeed()
+		assert.Len(t, sa, 32)
+		assert.Equal(t, sa, sb)
+		assert.NotEqual(t, sa, sc)
+	}
+
+	// 由同一个种子创建的随机数序列相同
+	seed, _ := CryptoRng{}.NewSeed()
+	r1, r2 := CryptoRng{}.New(seed), NewRng(dto.RngConf{Mode: RngModeSeeded}).New(seed)
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, r1.Int63n(1000), r2.Int63n(1000))
+	}
+}
+
+func TestPrizePoolUc_Odds(t *testing.T) {
+	lotterConf := dto.LotteryConf{ActivityId: 12345, Price: 100, FeaturedRate: 50}
+	lotterConf.StarLevels = []*dto.StarLevel{
+		{Level: 1, Weight: 90, Prizes: []*dto.Prize{{Id: 1, Num: 1, Weight: 2}, {Id: 2, Num: 1, Weight: 1}}},
+		{Level: 2, Weight: 10, Featured: []int64{3}, Prizes: []*dto.Prize{{Id: 3, Num: 1, Weight: 1}, {Id: 4, Num: 1, Weight: 1}}},
+	}
+	l, _ := logger.New(nil)
+	uc, err := NewPrizePoolUc(l, lotterConf)
+	assert.NoError(t, err)
+
+	odds := uc.Odds(context.Background())
+	sum := 0.0
+	for _, level := range odds.Levels {
+		for _, prize := range level.Prizes {
+			sum += prize.Rate
+		}
+	}
+	assert.InDelta(t, 1.0, sum, 1e-9)
+	assert.InDelta(t, 0.6, odds.Levels[0].Prizes[0].Rate, 1e-9)
+	assert.InDelta(t, 0.05, odds.Levels[1].Prizes[0].Rate, 1e-9)
+	assert.True(t, odds.Levels[1].Prizes[0].Featured)
+	// 无保底时平均10抽出最高星级，UP占2/3，平均15抽出UP
+	assert.InDelta(t, 10.0, odds.Pity.ExpectedDraws, 1e-6)
+	assert.InDelta(t, 2.0/3.0, odds.Pity.FeaturedShare, 1e-9)
+	assert.InDelta(t, 15.0, odds.Pity.ExpectedFeaturedDraws, 1e-6)
+
+	// 硬保底10抽 E = (1-0.9^10)/0.1
+	lotterConf.Pity.Hard = 10
+	uc, err = NewPrizePoolUc(l, lotterConf)
+	assert.NoError(t, err)
+	odds = uc.Odds(context.Background())
+	assert.InDelta(t, (1-math.Pow(0.9, 10))/0.1, odds.Pity.ExpectedDraws, 1e-6)
+	assert.InDelta(t, 1/odds.Pity.ExpectedDraws, odds.Pity.TopRate, 1e-9)
+}
+
+func TestPrizePoolUc_LimitDay(t *testing.T) {
+	lotterConf := dto.LotteryConf{ActivityId: 12345, Price: 100, StarLevels: createStarLevels()}
+	lotterConf.Limit = dto.LimitConf{Daily: 100, Timezone: "Asia/Shanghai"}
+	l, _ := logger.New(nil)
+	uc, err := NewPrizePoolUc(l, lotterConf)
+	assert.NoError(t, err)
+
+	// UTC 16:00 已是东八区的第二天
+	now := time.Date(2024, 1, 1, 16, 0, 0, 0, time.UTC)
+	assert.Equal(t, "20240102", uc.getLimitDay(context.Background(), now))
+	assert.Equal(t, "20240101", uc.getLimitDay(context.Background(), now.Add(-time.Second)))
+
+	lotterConf.Limit.Timezone = "Mars/Olympus"
+	_, err = NewPrizePoolUc(l, lotterConf)
+	assert.Error(t, err)
+}
+
+// linearSampler 改用别名表之前的累计权重线性扫描实现，用于对比
+type linearSampler struct {
+	weights []int64
+}
+
+func newLinearSampler(weights []int64) *linearSampler {
+	cum := make([]int64, len(weights))
+	total := int64(0)
+	for i, w := range weights {
+		total += w
+		cum[i] = total
+	}
+	return &linearSampler{weights: cum}
+}
+
+func (s *linearSampler) random(r *rand.Rand) int {
+	randVal := r.Int63n(s.weights[len(s.weights)-1])
+	for i, w := range s.weights {
+		if randVal < w {
+			return i
+		}
+	}
+	return -1
+}
+
+func prizeWeights(n int) []int64 {
+	weights := make([]int64, n)
+	for i := range weights {
+		weights[i] = int64(i + 1)
+	}
+	return weights
+}
+
+func TestAliasTable_Equivalence(t *testing.T) {
+	weights := prizeWeights(60)
+	weights[10] = 0 // 权重为0的奖品不会被抽中
+	alias := newAliasTable(weights)
+	linear := newLinearSampler(weights)
+	r := rand.New(rand.NewSource(1))
+	n := 600000
+
+	aliasCounts := make([]int, len(weights))
+	linearCounts := make([]int, len(weights))
+	for i := 0; i < n; i++ {
+		aliasCounts[alias.random(r)]++
+		linearCounts[linear.random(r)]++
+	}
+	assert.Equal(t, 0, aliasCounts[10])
+
+	// 两种实现分别对配置权重做卡方检验，自由度58，p=0.001的临界值约为93
+	chiSquare := func(counts []int) float64 {
+		sum := 0.0
+		for i, w := range weights {
+			if w == 0 {
+				continue
+			}
+			expected := float64(n) * float64(w) / float64(alias.total)
+			diff := float64(counts[i]) - expected
+			sum += diff * diff / expected
+		}
+		return sum
+	}
+	assert.Less(t, chiSquare(aliasCounts), 93.0)
+	assert.Less(t, chiSquare(linearCounts), 93.0)
+}
+
+func TestPrizePoolUc_KeepConfWeight(t *testing.T) {
+	starLevels := createStarLevels()
+	lotterConf := dto.LotteryConf{ActivityId: 12345, Price: 100, StarLevels: starLevels}
+
+	l, _ := logger.New(nil)
+	_, err := NewPrizePoolUc(l, lotterConf)
+	assert.NoError(t, err)
+
+	// 创建奖池后配置中的权重保持不变，可以用同一份配置重复创建
+	assert.Equal(t, int64(30), starLevels[1].Weight)
+	assert.Equal(t, int64(61), starLevels[1].Prizes[0].Weight)
+	_, err = NewPrizePoolUc(l, lotterConf)
+	assert.NoError(t, err)
+
+	// 权重总和为0时配置错误
+	lotterConf.StarLevels = []*dto.StarLevel{{Level: 1, Weight: 1, Prizes: []*dto.Prize{{Id: 1, Num: 1}}}}
+	_, err = NewPrizePoolUc(l, lotterConf)
+	assert.Error(t, err)
+}
+
+func BenchmarkSampler_Linear(b *testing.B) {
+	s := newLinearSampler(prizeWeights(500))
+	r := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.random(r)
+	}
+}
+
+func BenchmarkSampler_Alias(b *testing.B) {
+	s := newAliasTable(prizeWeights(500))
+	r := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.random(r)
+	}
+}
+
+func BenchmarkRandomPrizes(b *testing.B) {
+	lotterConf := dto.LotteryConf{ActivityId: 12345, Price: 100, StarLevels: createStarLevels()}
+	l, _ := logger.New(nil)
+	uc, err := NewPrizePoolUc(l, lotterConf)
+	if err != nil {
+		b.Fatalf("Failed to create PrizePoolUc: %v", err)
+	}
+
+	ctx := context.Background()
+	drawNum := int64(10)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := uc.RandomPrizes(ctx, 0, drawNum, &dto.DrawState{}, nil); err != nil {
+				b.Errorf("RandomPrizes failed: %v", err)
+			}
+		}
+	})
+}
 
 func createStarLevels() []*dto.StarLevel {
 	starLevels := make([]*dto.StarLevel, 3)
@@ -145,3 +533,252 @@ func createPrizes(start, end int) []*dto.Prize {
 //		t.Errorf("Total count (%d) does not match total draws (%d)", totalCount, totalDraws)
 //	}
 //}
+
+func TestSelectPricing(t *testing.T) {
+	conf := dto.LotteryConf{Price: 100}
+	assert.Equal(t, []dto.PriceConf{{Currency: types.CurrencyStone, Price: 100}}, conf.PriceTable())
+
+	conf.Pricing = []dto.PriceConf{
+		{Currency: types.CurrencyCrystal, Price: 160, Bundles: []dto.BundleConf{{DrawNum: 10, Price: 1440}}},
+		{Currency: types.CurrencyStone, Price: 160},
+	}
+	pricing, err := selectPricing(conf.PriceTable(), "", 0)
+	assert.Nil(t, err)
+	assert.Len(t, pricing, 2)
+	assert.Equal(t, int64(1440), pricing[0].Cost(10))
+	assert.Equal(t, int64(480), pricing[0].Cost(3))
+
+	pricing, err = selectPricing(conf.PriceTable(), types.CurrencyStone, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1600), pricing[0].Cost(10))
+
+	_, err = selectPricing(conf.PriceTable(), types.CurrencyGold, 0)
+	assert.Equal(t, cerror.ErrCurrencyLimit, err)
+
+	// 抽奖券优先，指定物品时只使用该物品
+	conf.Pricing = append([]dto.PriceConf{
+		{Currency: types.CurrencyItem, ItemId: 2001, Price: 1},
+		{Currency: types.CurrencyItem, ItemId: 2002, Price: 1},
+	}, conf.Pricing...)
+	pricing, err = selectPricing(conf.PriceTable(), types.CurrencyItem, 0)
+	assert.Nil(t, err)
+	assert.Len(t, pricing, 2)
+	pricing, err = selectPricing(conf.PriceTable(), types.CurrencyItem, 2002)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2002), pricing[0].ItemId)
+	assert.Equal(t, int64(10), pricing[0].Cost(10))
+	_, err = selectPricing(conf.PriceTable(), types.CurrencyItem, 2003)
+	assert.Equal(t, cerror.ErrCurrencyLimit, err)
+}
+
+func TestConvertDuplicate(t *testing.T) {
+	rules := map[int64]*dto.DuplicateConf{
+		501: {ItemId: 9001, Num: 10},
+		502: {Currency: types.CurrencyStone, Num: 50},
+	}
+	prizes := []*dto.Item{{Id: 101, Num: 2}, {Id: 501, Num: 1}, {Id: 501, Num: 1}, {Id: 502, Num: 1}}
+
+	// 未拥有时保留第一个，同一次抽奖的第二个转换
+	items, converted := convertDuplicate(prizes, rules, map[int64]int64{})
+	assert.Equal(t, map[int64]int64{101: 2, 501: 1, 502: 1, 9001: 10}, items)
+	assert.Equal(t, []*dto.Conversion{{PrizeId: 501, Num: 1, ItemId: 9001, Amount: 10}}, converted)
+
+	// 已拥有时全部转换
+	items, converted = convertDuplicate(prizes, rules, map[int64]int64{501: 1, 502: 1})
+	assert.Equal(t, map[int64]int64{101: 2, 9001: 20}, items)
+	assert.Equal(t, []*dto.Conversion{
+		{PrizeId: 501, Num: 1, ItemId: 9001, Amount: 10},
+		{PrizeId: 501, Num: 1, ItemId: 9001, Amount: 10},
+		{PrizeId: 502, Num: 1, Currency: types.CurrencyStone, Amount: 50},
+	}, converted)
+}
+
+func TestExpandContent(t *testing.T) {
+	items := map[int64]int64{101: 1}
+	at := &entity.UserAsset{UserID: 1}
+	content := &dto.PrizeContent{Items: []dto.Item{{Id: 101, Num: 2}, {Id: 102, Num: 1}}, Gold: 500}
+	expandContent(items, at, content, 2)
+	assert.Equal(t, map[int64]int64{101: 5, 102: 2}, items)
+	assert.Equal(t, int64(1000), at.Gold)
+	assert.Equal(t, int64(0), at.Stone)
+}
+
+func TestPrizePoolUc_Segment(t *testing.T) {
+	l, _ := logger.New(nil)
+	conf := dto.LotteryConf{ActivityId: 1, Price: 100, StarLevels: createStarLevels(), Fallback: dto.Item{Id: 1, Num: 1}}
+	conf.Segments = []dto.SegmentConf{
+		{Name: "new", NewUserDays: 7, StarLevels: []*dto.StarLevel{{Level: 1, Weight: 1, Prizes: []*dto.Prize{{Id: 1001, Num: 1, Weight: 1, Stock: 10}}}}},
+		{Name: "vip", MinVip: 3, StarLevels: []*dto.StarLevel{{Level: 1, Weight: 1, Prizes: []*dto.Prize{{Id: 2001, Num: 1, Weight: 1}}}}},
+	}
+	puc, err := NewPrizePoolUc(l, conf)
+	assert.Nil(t, err)
+	puc.setVersion(5)
+	ctx := context.Background()
+	now := time.Now()
+	assert.Equal(t, []string{"new", "vip"}, puc.getSegments(ctx))
+	assert.Equal(t, int64(10), puc.getStock(ctx)[1001])
+
+	draw := func(attr *dto.UserAttr) *dto.PrizeData {
+		data, err := puc.matchSegment(ctx, attr, now).RandomPrizes(ctx, 1, 1, &dto.DrawState{}, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(5), data.PoolVersion)
+		return data
+	}
+	// 新用户优先于VIP，按配置顺序匹配
+	data := draw(&dto.UserAttr{RegisterTime: now.Add(-24 * time.Hour), Vip: 5})
+	assert.Equal(t, "new", data.Segment)
+	assert.Equal(t, int64(1001), data.Prizes[0].Id)
+	data = draw(&dto.UserAttr{RegisterTime: now.Add(-8 * 24 * time.Hour), Vip: 3})
+	assert.Equal(t, "vip", data.Segment)
+	data = draw(&dto.UserAttr{RegisterTime: now.Add(-8 * 24 * time.Hour), Vip: 2})
+	assert.Equal(t, "", data.Segment)
+	data = draw(nil)
+	assert.Equal(t, "", data.Segment)
+
+	spuc, err := puc.getSegmentPool(ctx, "vip")
+	assert.Nil(t, err)
+	assert.Equal(t, "vip", spuc.Odds(ctx).Segment)
+	_, err = puc.getSegmentPool(ctx, "svip")
+	assert.Equal(t, cerror.ErrNoSegment, err)
+}
+
+func TestPrizePoolUc_Box(t *testing.T) {
+	l, _ := logger.New(nil)
+	conf := dto.LotteryConf{ActivityId: 1, Price: 100, Mode: types.ActivityModeBox}
+	conf.Box = dto.BoxConf{Prizes: []dto.BoxPrize{{Id: 1, Num: 1, Count: 7}, {Id: 2, Num: 5, Count: 2}, {Id: 3, Num: 1, Count: 1}}, GrandPrize: 3}
+	puc, err := NewPrizePoolUc(l, conf)
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	// 抽完一箱的奖品与箱子内容完全一致
+	state := &dto.DrawState{}
+	got := make(map[int64]int64)
+	for i := 0; i < 3; i++ {
+		data, err := puc.RandomPrizes(ctx, 1, 3, state, nil)
+		assert.Nil(t, err)
+		for _, item := range data.Prizes {
+			got[item.Id]++
+		}
+	}
+	_, err = puc.RandomPrizes(ctx, 1, 2, state, nil)
+	assert.Equal(t, cerror.ErrBoxNotEnough, err)
+	data, err := puc.RandomPrizes(ctx, 1, 1, state, nil)
+	assert.Nil(t, err)
+	got[data.Prizes[0].Id]++
+	assert.Equal(t, map[int64]int64{1: 7, 2: 2, 3: 1}, got)
+	assert.True(t, state.Box.GrandTaken)
+	assert.Equal(t, int64(10), state.DrawTotal)
+
+	resp := puc.getBoxResp(ctx, 1, state.Box)
+	assert.Equal(t, int64(0), resp.Left)
+	assert.Equal(t, int64(10), resp.Total)
+	odds := puc.Odds(ctx)
+	assert.Equal(t, 0.1, odds.Levels[0].Prizes[2].Rate)
+}
+
+func TestPrizePoolUc_StepUp(t *testing.T) {
+	l, _ := logger.New(nil)
+	conf := dto.LotteryConf{ActivityId: 1, Price: 100, StarLevels: createStarLevels(), Mode: types.ActivityModeStepUp}
+	conf.Steps = []dto.StepConf{
+		{DrawNum: 10, Pricing: []dto.PriceConf{{Currency: types.CurrencyStone, Price: 50}}},
+		{DrawNum: 10},
+		{DrawNum: 5, MinLevel: 3},
+	}
+	puc, err := NewPrizePoolUc(l, conf)
+	assert.Nil(t, err)
+	ctx := context.Background()
+	assert.Len(t, puc.getSteps(ctx), 3)
+	_, err = puc.getStepPool(ctx, 3)
+	assert.Equal(t, cerror.ErrNoStep, err)
+
+	// 未配置价格的阶梯使用活动价格
+	step1, _ := puc.getStepPool(ctx, 0)
+	assert.Equal(t, int64(500), step1.getPricing(ctx)[0].Cost(10))
+	step2, _ := puc.getStepPool(ctx, 1)
+	assert.Equal(t, int64(1000), step2.getPricing(ctx)[0].Cost(10))
+
+	// 第三阶梯每次必出最高星级，抽奖后阶梯数加1
+	step3, _ := puc.getStepPool(ctx, 2)
+	state := &dto.DrawState{Step: 2}
+	for i := 0; i < 100; i++ {
+		data, err := step3.RandomPrizes(ctx, 1, 5, state, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, data.Step)
+		top := false
+		for _, item := range data.Prizes {
+			top = top || item.Id > 90
+		}
+		assert.True(t, top)
+	}
+	assert.Equal(t, int64(102), state.Step)
+
+	// 活动只配置 pricing 时，未配置价格的阶梯使用活动的 pricing
+	conf.Price = 0
+	conf.Pricing = []dto.PriceConf{{Currency: types.CurrencyCrystal, Price: 100}}
+	puc, err = NewPrizePoolUc(l, conf)
+	assert.Nil(t, err)
+	step2, _ = puc.getStepPool(ctx, 1)
+	assert.Equal(t, []dto.PriceConf{{Currency: types.CurrencyCrystal, Price: 100}}, step2.getPricing(ctx))
+	step1, _ = puc.getStepPool(ctx, 0)
+	assert.Equal(t, types.CurrencyStone, step1.getPricing(ctx)[0].Currency)
+}
+
+func TestPrizePoolUc_Free(t *testing.T) {
+	l, _ := logger.New(nil)
+	ctx := context.Background()
+	conf := dto.LotteryConf{ActivityId: 1, Price: 100, StarLevels: createStarLevels()}
+	puc, err := NewPrizePoolUc(l, conf)
+	assert.Nil(t, err)
+	_, _, ok := puc.getFree(ctx, time.Now())
+	assert.False(t, ok)
+
+	// 每日免费按配置的时区零点重置，UTC 16:00 已是上海的第二天
+	conf.Free = dto.FreeConf{Daily: true, Timezone: "Asia/Shanghai"}
+	puc, err = NewPrizePoolUc(l, conf)
+	assert.Nil(t, err)
+	period, ttl, ok := puc.getFree(ctx, time.Date(2024, 3, 1, 16, 0, 0, 0, time.UTC))
+	assert.True(t, ok)
+	assert.Equal(t, "20240302", period)
+	assert.Equal(t, 48*time.Hour, ttl)
+
+	conf.Free = dto.FreeConf{Hours: 8}
+	puc, err = NewPrizePoolUc(l, conf)
+	assert.Nil(t, err)
+	period, ttl, ok = puc.getFree(ctx, time.Now())
+	assert.True(t, ok)
+	assert.Equal(t, "", period)
+	assert.Equal(t, 8*time.Hour, ttl)
+}
+
+func TestPrizePoolUc_Variant(t *testing.T) {
+	l, _ := logger.New(nil)
+	conf := dto.LotteryConf{ActivityId: 1, Price: 100, StarLevels: createStarLevels()}
+	conf.Variants = []dto.VariantConf{
+		{Id: "a", Percent: 30},
+		{Id: "b", Percent: 70, StarLevels: []*dto.StarLevel{{Level: 1, Weight: 1, Prizes: []*dto.Prize{{Id: 1001, Num: 1, Weight: 1}}}},
+			Pricing: []dto.PriceConf{{Currency: types.CurrencyStone, Price: 80}}},
+	}
+	puc, err := NewPrizePoolUc(l, conf)
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	// 同一用户总是分到同一分组，分组比例接近配置的流量百分比
+	count := make(map[string]int)
+	for userId := int64(1); userId <= 10000; userId++ {
+		v := puc.matchVariant(ctx, userId)
+		assert.Equal(t, v, puc.matchVariant(ctx, userId))
+		data, err := v.RandomPrizes(ctx, userId, 1, nil, nil)
+		assert.Nil(t, err)
+		count[data.Variant]++
+		if data.Variant == "b" {
+			assert.Equal(t, int64(1001), data.Prizes[0].Id)
+			assert.Equal(t, int64(80), v.getPricing(ctx)[0].Price)
+			assert.Equal(t, int64(1), data.TopNum)
+		}
+	}
+	assert.InDelta(t, 3000, count["a"], 300)
+	assert.InDelta(t, 7000, count["b"], 300)
+
+	_, err = puc.getVariantPool(ctx, "c")
+	assert.Equal(t, cerror.ErrNoVariant, err)
+}
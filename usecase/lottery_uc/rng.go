@@ -0,0 +1,98 @@
+package lottery_uc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"github.com/linchengzhi/lottery/domain/dto"
+	mrand "math/rand"
+	randv2 "math/rand/v2"
+	"sync"
+)
+
+const (
+	RngModeCrypto = "crypto" // 种子来自 crypto/rand，默认模式
+	RngModeSeeded = "seeded" // 种子序列由配置的主种子决定，仅用于测试和压测
+)
+
+// Rng 抽奖随机数生成器，由种子创建的随机数序列是确定的，保存种子即可重现抽奖
+type Rng interface {
+	// NewSeed 生成一个32字节的新种子
+	NewSeed() ([]byte, error)
+	// New 由种子创建随机数，相同种子得到相同的随机序列
+	New(seed []byte) *mrand.Rand
+}
+
+// NewRng 根据配置创建随机数生成器，未配置时使用 crypto 模式
+func NewRng(conf dto.RngConf) Rng {
+	if conf.Mode == RngModeSeeded {
+		return NewSeededRng(conf.Seed)
+	}
+	return CryptoRng{}
+}
+
+// CryptoRng 种子来自操作系统的密码学随机数
+type CryptoRng struct{}
+
+func (CryptoRng) NewSeed() ([]byte, error) {
+	seed := make([]byte, 32)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+	return seed, nil
+}
+
+func (CryptoRng) New(seed []byte) *mrand.Rand {
+	return newChaCha8Rand(seed)
+}
+
+// SeededRng 种子序列由主种子决定，重启后生成相同的种子序列
+type SeededRng struct {
+	mu *sync.Mutex
+	r  *mrand.Rand
+}
+
+func NewSeededRng(seed int64) SeededRng {
+	return SeededRng{
+		mu: &sync.Mutex{},
+		r:  mrand.New(mrand.NewSource(seed)),
+	}
+}
+
+func (g SeededRng) NewSeed() ([]byte, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	seed := make([]byte, 32)
+	g.r.Read(seed)
+	return seed, nil
+}
+
+func (g SeededRng) New(seed []byte) *mrand.Rand {
+	return newChaCha8Rand(seed)
+}
+
+// chacha8Source 将 ChaCha8 适配为 math/rand 的随机源
+type chacha8Source struct {
+	c *randv2.ChaCha8
+}
+
+func (s chacha8Source) Uint64() uint64 {
+	return s.c.Uint64()
+}
+
+func (s chacha8Source) Int63() int64 {
+	return int64(s.c.Uint64() >> 1)
+}
+
+// Seed 随机序列完全由创建时的种子决定，不支持重新设置
+func (s chacha8Source) Seed(int64) {}
+
+// newChaCha8Rand 由种子创建 ChaCha8 随机数，种子不是32字节时取其 sha256
+func newChaCha8Rand(seed []byte) *mrand.Rand {
+	var key [32]byte
+	if len(seed) == len(key) {
+		copy(key[:], seed)
+	} else {
+		key = sha256.Sum256(seed)
+	}
+	return mrand.New(chacha8Source{c: randv2.NewChaCha8(key)})
+}
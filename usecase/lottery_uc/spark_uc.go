@@ -0,0 +1,185 @@
+package lottery_uc
+
+import (
+	"context"
+	"github.com/linchengzhi/lottery/domain/cerror"
+	"github.com/linchengzhi/lottery/domain/dto"
+	"github.com/linchengzhi/lottery/domain/entity"
+	"github.com/linchengzhi/lottery/domain/types"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+)
+
+// 兑换奖品的积分流水和物品记录ID，与抽奖的记录区分
+func sparkRequestId(requestId string) string {
+	return requestId + "s"
+}
+
+// addSpark 发奖时按抽奖请求计入兑换积分，请求ID重复时不重复计入
+func (uc *LotteryUc) addSpark(ctx context.Context, aStream *dto.AwardStream) error {
+	data := aStream.PrizeData
+	if data.Spark <= 0 {
+		return nil
+	}
+	inserted, err := uc.sparkRepo.Add(ctx, data.ActivityId, &entity.LotterySparkRecord{
+		UserID:    data.UserId,
+		Points:    data.Spark,
+		Type:      types.SparkTypeDraw,
+		RequestID: aStream.RequestId,
+	})
+	if err != nil {
+		return err
+	}
+	if inserted {
+		if err = uc.sparkCache.Del(ctx, data.ActivityId, data.UserId); err != nil {
+			uc.log.Warn("发奖 删除兑换积分缓存失败", zap.Any("data", aStream), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// sparkPoints 获取用户积分，优先读取缓存
+func (uc *LotteryUc) sparkPoints(ctx context.Context, activityId, userId int64) (int64, error) {
+	points, ok, err := uc.sparkCache.Get(ctx, activityId, userId)
+	if err == nil && ok {
+		return points, nil
+	}
+	points, err = uc.sparkRepo.Sum(ctx, activityId, userId)
+	if err != nil {
+		return 0, err
+	}
+	if err = uc.sparkCache.Set(ctx, activityId, userId, points); err != nil {
+		uc.log.Warn("设置兑换积分缓存失败", zap.Int64("activityId", activityId), zap.Int64("userId", userId), zap.Error(err))
+	}
+	return points, nil
+}
+
+// Spark 获取用户的兑换积分，活动结束后积分清零
+func (uc *LotteryUc) Spark(ctx context.Context, req *dto.SparkReq) (*dto.SparkResp, error) {
+	puc, err := uc.getPrizePool(ctx, req.ActivityId)
+	if err != nil {
+		return nil, err
+	}
+	spark := puc.getSpark(ctx)
+	if spark.PerDraw <= 0 {
+		return nil, cerror.ErrNoSpark
+	}
+	resp := &dto.SparkResp{ActivityId: req.ActivityId, UserId: req.UserId, Cost: spark.Cost}
+	for i := range spark.Prizes {
+		resp.Prizes = append(resp.Prizes, &spark.Prizes[i])
+	}
+	if puc.getState(ctx, time.Now()) == types.ActivityStateEnded {
+		return resp, nil
+	}
+	resp.Points, err = uc.sparkPoints(ctx, req.ActivityId, req.UserId)
+	if err != nil {
+		uc.log.Warn("获取兑换积分失败", zap.Any("req", req), zap.Error(err))
+		return nil, cerror.ErrBusy
+	}
+	return resp, nil
+}
+
+// RedeemSpark 扣除积分兑换奖品，先写积分流水再发放物品，相同请求ID重试时只补发物品
+func (uc *LotteryUc) RedeemSpark(ctx context.Context, req *dto.RedeemReq) (*dto.RedeemResp, error) {
+	puc, err := uc.getPrizePool(ctx, req.ActivityId)
+	if err != nil {
+		return nil, err
+	}
+	spark := puc.getSpark(ctx)
+	if spark.PerDraw <= 0 {
+		return nil, cerror.ErrNoSpark
+	}
+	switch puc.getState(ctx, time.Now()) {
+	case types.ActivityStateScheduled:
+		return nil, cerror.ErrLotteryNoStart
+	case types.ActivityStatePaused:
+		return nil, cerror.ErrLotteryPaused
+	case types.ActivityStateEnded:
+		return nil, cerror.ErrLotteryEnded
+	}
+	var prize *dto.Item
+	for i := range spark.Prizes {
+		if spark.Prizes[i].Id == req.PrizeId {
+			prize = &spark.Prizes[i]
+			break
+		}
+	}
+	if prize == nil {
+		return nil, cerror.ErrSparkPrize
+	}
+
+	// 与抽奖使用同一个用户锁，保证积分检查和扣除串行
+	lockToken, locked, err := uc.stateCache.Lock(ctx, req.ActivityId, req.UserId)
+	if err != nil {
+		uc.log.Warn("兑换失败 用户加锁失败", zap.Any("req", req), zap.Error(err))
+		return nil, cerror.ErrBusy
+	}
+	if !locked {
+		return nil, cerror.ErrFrequently
+	}
+	defer uc.stateCache.Unlock(context.Background(), req.ActivityId, req.UserId, lockToken)
+
+	key := sparkRequestId(req.RequestId)
+	record, err := uc.sparkRepo.GetByRequestID(ctx, req.ActivityId, key)
+	if err != nil {
+		uc.log.Warn("兑换失败 查询积分流水失败", zap.Any("req", req), zap.Error(err))
+		return nil, cerror.ErrBusy
+	}
+	if record == nil {
+		// 以mysql流水为准检查积分，不使用缓存
+		points, err := uc.sparkRepo.Sum(ctx, req.ActivityId, req.UserId)
+		if err != nil {
+			uc.log.Warn("兑换失败 查询积分失败", zap.Any("req", req), zap.Error(err))
+			return nil, cerror.ErrBusy
+		}
+		if points < spark.Cost {
+			return nil, cerror.ErrSparkLess
+		}
+		_, err = uc.sparkRepo.Add(ctx, req.ActivityId, &entity.LotterySparkRecord{
+			UserID:    req.UserId,
+			Points:    -spark.Cost,
+			Type:      types.SparkTypeRedeem,
+			PrizeID:   prize.Id,
+			RequestID: key,
+		})
+		if err != nil {
+			uc.log.Warn("兑换失败 扣除积分失败", zap.Any("req", req), zap.Error(err))
+			return nil, cerror.ErrBusy
+		}
+		if err = uc.sparkCache.Del(ctx, req.ActivityId, req.UserId); err != nil {
+			uc.log.Warn("兑换 删除兑换积分缓存失败", zap.Any("req", req), zap.Error(err))
+		}
+	} else if record.PrizeID != prize.Id {
+		return nil, cerror.ErrSparkPrize
+	}
+
+	items := map[int64]int64{prize.Id: prize.Num}
+	err = uc.assetUc.UpdateItems(ctx, req.UserId, items, key, req.RequestTime)
+	if err != nil && !strings.Contains(err.Error(), "Duplicate entry") {
+		// 积分已扣除，使用相同请求ID重试即可补发
+		uc.log.Error("兑换失败 发放物品失败", zap.Any("req", req), zap.Error(err))
+		return nil, cerror.ErrBusy
+	}
+
+	resp := &dto.RedeemResp{RequestId: req.RequestId, Prize: prize}
+	if resp.Points, err = uc.sparkPoints(ctx, req.ActivityId, req.UserId); err != nil {
+		uc.log.Warn("兑换 获取兑换积分失败", zap.Any("req", req), zap.Error(err))
+	}
+	uc.log.Info("兑换奖品", zap.Any("req", req), zap.Int64("cost", spark.Cost))
+	return resp, nil
+}
+
+// expireSpark 活动结束时清零用户的兑换积分
+func (uc *LotteryUc) expireSpark(ctx context.Context, puc IPrizePoolUc) error {
+	if puc.getSpark(ctx).PerDraw <= 0 {
+		return nil
+	}
+	activityId := puc.getActivityId(ctx)
+	users, err := uc.sparkRepo.Expire(ctx, activityId)
+	if err != nil {
+		return err
+	}
+	uc.log.Info("活动结束 兑换积分清零", zap.Int64("activityId", activityId), zap.Int64("users", users))
+	return nil
+}
@@ -0,0 +1,61 @@
+package lottery_uc
+
+import (
+	"context"
+	"github.com/linchengzhi/lottery/domain/dto"
+	"github.com/linchengzhi/lottery/repository/mysql_repo"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	UserAttrTable  = "table"  // 从 user_profile 表读取，默认来源
+	UserAttrHeader = "header" // 网关在请求头中传入用户属性，需显式配置
+)
+
+// UserAttrProvider 提供用户属性，用于选择分群奖池，属性未知时返回 nil
+type UserAttrProvider interface {
+	GetUserAttr(ctx context.Context, req *dto.DrawReq) (*dto.UserAttr, error)
+}
+
+// NewUserAttrProvider 根据配置创建用户属性来源，未配置时从 user_profile 表读取
+func NewUserAttrProvider(conf dto.UserAttrConf, repoMysql mysql_repo.RepoMysql) UserAttrProvider {
+	if conf.Provider == UserAttrHeader {
+		return HeaderAttrProvider{}
+	}
+	return TableAttrProvider{repo: repoMysql.UserProfileRepo}
+}
+
+// HeaderAttrProvider 使用网关在请求头中传入的用户属性，由接口层写入 DrawReq.Attr
+// 客户端可以伪造请求头进入其他分群，网关必须删除客户端传入的 user_register_time 和 user_vip 后再写入
+type HeaderAttrProvider struct{}
+
+func (HeaderAttrProvider) GetUserAttr(ctx context.Context, req *dto.DrawReq) (*dto.UserAttr, error) {
+	return req.Attr, nil
+}
+
+// TableAttrProvider 从 user_profile 表读取用户属性
+type TableAttrProvider struct {
+	repo mysql_repo.UserProfileRepo
+}
+
+func (p TableAttrProvider) GetUserAttr(ctx context.Context, req *dto.DrawReq) (*dto.UserAttr, error) {
+	profile, err := p.repo.Get(ctx, req.UserId)
+	if err != nil || profile == nil {
+		return nil, err
+	}
+	return &dto.UserAttr{RegisterTime: profile.RegisterTime, Vip: profile.Vip}, nil
+}
+
+// segmentPool 按用户属性选择分群奖池，活动没有分群时不读取用户属性
+func (uc *LotteryUc) segmentPool(ctx context.Context, puc IPrizePoolUc, req *dto.DrawReq) (IPrizePoolUc, error) {
+	if len(puc.getSegments(ctx)) == 0 {
+		return puc, nil
+	}
+	attr, err := uc.userAttr.GetUserAttr(ctx, req)
+	if err != nil {
+		uc.log.Warn("抽奖失败 获取用户属性失败", zap.Any("req", req), zap.Error(err))
+		return nil, err
+	}
+	return puc.matchSegment(ctx, attr, time.Now()), nil
+}
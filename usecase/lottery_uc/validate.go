@@ -0,0 +1,565 @@
+package lottery_uc
+
+import (
+	"fmt"
+	"github.com/linchengzhi/lottery/domain/dto"
+	"github.com/linchengzhi/lottery/domain/types"
+	"time"
+)
+
+// ValidateLotteryConf 校验活动配置，返回全部错误，路径与yaml字段一致，例如 star_levels[2].prizes[0].weight
+func ValidateLotteryConf(conf dto.LotteryConf) dto.ConfErrors {
+	v := new(confValidator)
+	if conf.ActivityId <= 0 {
+		v.add("activity_id", "必须大于0")
+	}
+	if len(conf.Pricing) == 0 && conf.Price <= 0 && conf.Mode != types.ActivityModeStepUp {
+		v.add("price", "必须大于0")
+	}
+	v.pricing("pricing", conf.Pricing)
+	if !conf.StartTime.IsZero() && !conf.EndTime.IsZero() && !conf.StartTime.Before(conf.EndTime) {
+		v.add("end_time", "必须晚于 start_time")
+	}
+	if conf.State != "" && conf.State != types.ActivityStatePaused {
+		v.add("state", "只支持 paused")
+	}
+	if conf.FeaturedRate < 0 || conf.FeaturedRate > 100 {
+		v.add("featured_rate", "必须在0到100之间")
+	}
+
+	v.limit(conf.Limit)
+	v.spark(conf.Spark)
+	v.free(conf)
+	switch conf.Mode {
+	case types.ActivityModeBox:
+		v.box(conf)
+		return v.errs
+	case types.ActivityModeStepUp:
+		v.steps(conf)
+	case "":
+		if len(conf.Steps) > 0 {
+			v.add("steps", "仅 step_up 模式可配置")
+		}
+	default:
+		v.add("mode", "只支持 box 或 step_up")
+	}
+	if len(conf.Box.Prizes) > 0 || conf.Box.GrandPrize != 0 {
+		v.add("box", "仅 box 模式可配置")
+	}
+	top := v.starLevels("star_levels", conf.StarLevels)
+	v.pity(conf.Pity)
+	v.batch(conf.BatchGuarantee, conf.StarLevels)
+	if top != nil {
+		v.featured("star_levels", conf.StarLevels, top)
+	}
+	v.segments(conf)
+	v.variants(conf)
+	v.fallback(conf)
+	return v.errs
+}
+
+// box 箱子模式只使用 box 中的奖品，星级、保底和分群配置不生效
+func (v *confValidator) box(conf dto.LotteryConf) {
+	if len(conf.StarLevels) > 0 {
+		v.add("star_levels", "box 模式不能配置")
+	}
+	if conf.Pity.Hard != 0 || conf.Pity.Soft != 0 {
+		v.add("pity", "box 模式不能配置")
+	}
+	if conf.BatchGuarantee.BatchSize != 0 {
+		v.add("batch_guarantee", "box 模式不能配置")
+	}
+	if len(conf.Segments) > 0 {
+		v.add("segments", "box 模式不能配置")
+	}
+	if len(conf.Variants) > 0 {
+		v.add("variants", "box 模式不能配置")
+	}
+	if len(conf.Box.Prizes) == 0 {
+		v.add("box.prizes", "不能为空")
+	}
+	ids := make(map[int64]string)
+	for i, prize := range conf.Box.Prizes {
+		path := fmt.Sprintf("box.prizes[%d]", i)
+		if prize.Id <= 0 {
+			v.add(path+".id", "必须大于0")
+		} else if first, ok := ids[prize.Id]; ok {
+			v.add(path+".id", "奖品 %d 与 %s 重复", prize.Id, first)
+		} else {
+			ids[prize.Id] = path
+		}
+		if prize.Num <= 0 {
+			v.add(path+".num", "必须大于0")
+		}
+		if prize.Count <= 0 {
+			v.add(path+".count", "必须大于0")
+		}
+	}
+	if _, ok := ids[conf.Box.GrandPrize]; !ok {
+		v.add("box.grand_prize", "必须是箱子中的奖品")
+	}
+}
+
+type confValidator struct {
+	errs dto.ConfErrors
+	ids  map[int64]string // 奖品ID->第一次出现的路径
+}
+
+func (v *confValidator) add(path, format string, args ...interface{}) {
+	v.errs = append(v.errs, dto.ConfError{Path: path, Msg: fmt.Sprintf(format, args...)})
+}
+
+// starLevels 校验星级和奖品，返回最高星级，奖品ID在同一奖池内不能重复
+func (v *confValidator) starLevels(prefix string, levels []*dto.StarLevel) *dto.StarLevel {
+	if len(levels) == 0 {
+		v.add(prefix, "不能为空")
+		return nil
+	}
+	v.ids = make(map[int64]string)
+	seen := make(map[int]string)
+	var top *dto.StarLevel
+	for i, level := range levels {
+		path := fmt.Sprintf("%s[%d]", prefix, i)
+		if level == nil {
+			v.add(path, "不能为空")
+			continue
+		}
+		if level.Level <= 0 {
+			v.add(path+".level", "必须大于0")
+		} else if first, ok := seen[level.Level]; ok {
+			v.add(path+".level", "星级 %d 与 %s 重复", level.Level, first)
+		} else {
+			seen[level.Level] = path
+		}
+		if level.Weight <= 0 {
+			v.add(path+".weight", "必须大于0")
+		}
+		if top == nil || level.Level > top.Level {
+			top = level
+		}
+
+		if len(level.Prizes) == 0 {
+			v.add(path+".prizes", "不能为空")
+		}
+		for j, prize := range level.Prizes {
+			v.prize(fmt.Sprintf("%s.prizes[%d]", path, j), prize)
+		}
+	}
+	return top
+}
+
+func (v *confValidator) prize(path string, prize *dto.Prize) {
+	if prize == nil {
+		v.add(path, "不能为空")
+		return
+	}
+	if prize.Id <= 0 {
+		v.add(path+".id", "必须大于0")
+	} else if first, ok := v.ids[prize.Id]; ok {
+		v.add(path+".id", "奖品 %d 与 %s 重复", prize.Id, first)
+	} else {
+		v.ids[prize.Id] = path
+	}
+	if prize.Num <= 0 {
+		v.add(path+".num", "必须大于0")
+	}
+	if prize.Weight <= 0 {
+		v.add(path+".weight", "必须大于0")
+	}
+	if prize.Stock < 0 {
+		v.add(path+".stock", "不能小于0")
+	}
+	if prize.Unique {
+		v.duplicate(path+".duplicate", prize.Duplicate)
+	} else if prize.Duplicate != nil {
+		v.add(path+".duplicate", "仅唯一奖品可配置")
+	}
+	if prize.Content != nil {
+		if prize.Unique {
+			v.add(path+".unique", "组合奖品不能是唯一奖品")
+		}
+		v.content(path+".content", prize.Content)
+	}
+}
+
+func (v *confValidator) content(path string, content *dto.PrizeContent) {
+	if len(content.Items) == 0 && content.Gold == 0 && content.Stone == 0 && content.Crystal == 0 {
+		v.add(path, "不能为空")
+	}
+	for i, item := range content.Items {
+		if item.Id <= 0 {
+			v.add(fmt.Sprintf("%s.items[%d].id", path, i), "必须大于0")
+		}
+		if item.Num <= 0 {
+			v.add(fmt.Sprintf("%s.items[%d].num", path, i), "必须大于0")
+		}
+	}
+	if content.Gold < 0 {
+		v.add(path+".gold", "不能小于0")
+	}
+	if content.Stone < 0 {
+		v.add(path+".stone", "不能小于0")
+	}
+	if content.Crystal < 0 {
+		v.add(path+".crystal", "不能小于0")
+	}
+}
+
+func (v *confValidator) duplicate(path string, rule *dto.DuplicateConf) {
+	if rule == nil {
+		v.add(path, "唯一奖品必须配置重复转换规则")
+		return
+	}
+	switch {
+	case rule.ItemId > 0 && rule.Currency != "":
+		v.add(path, "item_id 和 currency 只能配置一个")
+	case rule.ItemId > 0:
+	case rule.Currency == types.CurrencyGold, rule.Currency == types.CurrencyStone, rule.Currency == types.CurrencyCrystal:
+	case rule.Currency != "":
+		v.add(path+".currency", "不支持货币 %s", rule.Currency)
+	default:
+		v.add(path, "必须配置 item_id 或 currency")
+	}
+	if rule.Num <= 0 {
+		v.add(path+".num", "必须大于0")
+	}
+}
+
+func (v *confValidator) pricing(prefix string, pricing []dto.PriceConf) {
+	seen := make(map[string]bool)
+	seenItem := make(map[int64]bool)
+	for i, price := range pricing {
+		path := fmt.Sprintf("%s[%d]", prefix, i)
+		switch price.Currency {
+		case types.CurrencyGold, types.CurrencyStone, types.CurrencyCrystal:
+			if seen[price.Currency] {
+				v.add(path+".currency", "货币 %s 重复", price.Currency)
+			}
+			seen[price.Currency] = true
+			if price.ItemId != 0 {
+				v.add(path+".item_id", "仅货币为 item 时配置")
+			}
+		case types.CurrencyItem:
+			if price.ItemId <= 0 {
+				v.add(path+".item_id", "必须大于0")
+			} else if seenItem[price.ItemId] {
+				v.add(path+".item_id", "物品 %d 重复", price.ItemId)
+			}
+			seenItem[price.ItemId] = true
+		default:
+			v.add(path+".currency", "不支持货币 %s", price.Currency)
+		}
+		if price.Price <= 0 {
+			v.add(path+".price", "必须大于0")
+		}
+		for j, bundle := range price.Bundles {
+			if bundle.DrawNum <= 1 {
+				v.add(fmt.Sprintf("%s.bundles[%d].draw_num", path, j), "必须大于1")
+			}
+			if bundle.Price <= 0 {
+				v.add(fmt.Sprintf("%s.bundles[%d].price", path, j), "必须大于0")
+			}
+		}
+	}
+}
+
+func (v *confValidator) limit(limit dto.LimitConf) {
+	if limit.Daily < 0 {
+		v.add("limit.daily", "不能小于0")
+	}
+	if limit.Total < 0 {
+		v.add("limit.total", "不能小于0")
+	}
+	if limit.PerRequest < 0 {
+		v.add("limit.per_request", "不能小于0")
+	}
+	if limit.Timezone != "" {
+		if _, err := time.LoadLocation(limit.Timezone); err != nil {
+			v.add("limit.timezone", "时区 %s 不存在", limit.Timezone)
+		}
+	}
+}
+
+func (v *confValidator) pity(pity dto.PityConf) {
+	if pity.Hard < 0 {
+		v.add("pity.hard", "不能小于0")
+	}
+	if pity.Soft < 0 {
+		v.add("pity.soft", "不能小于0")
+	}
+	if pity.Hard > 0 && pity.Soft >= pity.Hard {
+		v.add("pity.soft", "必须小于 pity.hard")
+	}
+	if pity.SoftStep < 0 {
+		v.add("pity.soft_step", "不能小于0")
+	}
+	for i, step := range pity.SoftSteps {
+		if step < 0 {
+			v.add(fmt.Sprintf("pity.soft_steps[%d]", i), "不能小于0")
+		}
+	}
+}
+
+func (v *confValidator) batch(batch dto.BatchGuaranteeConf, levels []*dto.StarLevel) {
+	if batch.BatchSize < 0 {
+		v.add("batch_guarantee.batch_size", "不能小于0")
+	}
+	if batch.BatchSize <= 0 {
+		return
+	}
+	if !hasLevel(levels, batch.MinLevel) {
+		v.add("batch_guarantee.min_level", "没有不低于 %d 的星级", batch.MinLevel)
+	}
+}
+
+// segments 校验分群奖池，分群共用活动的价格、保底和替代奖品配置
+func (v *confValidator) segments(conf dto.LotteryConf) {
+	names := make(map[string]bool)
+	for i, seg := range conf.Segments {
+		path := fmt.Sprintf("segments[%d]", i)
+		if seg.Name == "" {
+			v.add(path+".name", "不能为空")
+		} else if names[seg.Name] {
+			v.add(path+".name", "分群 %s 重复", seg.Name)
+		}
+		names[seg.Name] = true
+		if seg.NewUserDays < 0 {
+			v.add(path+".new_user_days", "不能小于0")
+		}
+		if seg.MinVip < 0 {
+			v.add(path+".min_vip", "不能小于0")
+		}
+		if seg.MaxVip < 0 || (seg.MaxVip > 0 && seg.MaxVip < seg.MinVip) {
+			v.add(path+".max_vip", "不能小于0或小于 min_vip")
+		}
+		if seg.NewUserDays == 0 && seg.MinVip == 0 && seg.MaxVip == 0 {
+			v.add(path, "至少配置一个分群条件")
+		}
+		top := v.starLevels(path+".star_levels", seg.StarLevels)
+		if top != nil {
+			v.featured(path+".star_levels", seg.StarLevels, top)
+		}
+		if batch := conf.BatchGuarantee; batch.BatchSize > 0 && !hasLevel(seg.StarLevels, batch.MinLevel) {
+			v.add(path+".star_levels", "没有不低于 batch_guarantee.min_level %d 的星级", batch.MinLevel)
+		}
+	}
+}
+
+// free 免费抽奖不扣除资产，阶梯模式的阶梯必须付费推进
+func (v *confValidator) free(conf dto.LotteryConf) {
+	free := conf.Free
+	if free.Daily && free.Hours != 0 {
+		v.add("free", "daily 和 hours 只能配置一个")
+	}
+	if free.Hours < 0 {
+		v.add("free.hours", "不能小于0")
+	}
+	if free.Timezone != "" {
+		if _, err := time.LoadLocation(free.Timezone); err != nil {
+			v.add("free.timezone", "时区 %s 不存在", free.Timezone)
+		}
+	}
+	if conf.Mode == types.ActivityModeStepUp && (free.Daily || free.Hours > 0) {
+		v.add("free", "step_up 模式不能配置")
+	}
+}
+
+// spark 兑换积分未开启时不能配置兑换列表
+func (v *confValidator) spark(spark dto.SparkConf) {
+	if spark.PerDraw < 0 {
+		v.add("spark.per_draw", "不能小于0")
+	}
+	if spark.PerDraw <= 0 {
+		if spark.Cost != 0 || len(spark.Prizes) > 0 {
+			v.add("spark", "per_draw 为0时不能配置")
+		}
+		return
+	}
+	if spark.Cost <= 0 {
+		v.add("spark.cost", "必须大于0")
+	}
+	if len(spark.Prizes) == 0 {
+		v.add("spark.prizes", "不能为空")
+	}
+	ids := make(map[int64]bool)
+	for i, prize := range spark.Prizes {
+		path := fmt.Sprintf("spark.prizes[%d]", i)
+		if prize.Id <= 0 {
+			v.add(path+".id", "必须大于0")
+		} else if ids[prize.Id] {
+			v.add(path+".id", "奖品 %d 重复", prize.Id)
+		}
+		ids[prize.Id] = true
+		if prize.Num <= 0 {
+			v.add(path+".num", "必须大于0")
+		}
+	}
+}
+
+// variants 校验实验分组，流量百分比之和必须为100，分组不能与分群、阶梯同时使用
+func (v *confValidator) variants(conf dto.LotteryConf) {
+	if len(conf.Variants) == 0 {
+		return
+	}
+	if len(conf.Segments) > 0 {
+		v.add("variants", "不能与 segments 同时配置")
+	}
+	if conf.Mode != "" {
+		v.add("variants", "%s 模式不能配置", conf.Mode)
+	}
+	ids := make(map[string]bool)
+	total := int64(0)
+	for i, variant := range conf.Variants {
+		path := fmt.Sprintf("variants[%d]", i)
+		if variant.Id == "" || len(variant.Id) > 32 {
+			v.add(path+".id", "不能为空且不能超过32个字符")
+		} else if ids[variant.Id] {
+			v.add(path+".id", "分组 %s 重复", variant.Id)
+		}
+		ids[variant.Id] = true
+		if variant.Percent <= 0 {
+			v.add(path+".percent", "必须大于0")
+		}
+		total += variant.Percent
+		v.pricing(path+".pricing", variant.Pricing)
+		if len(variant.StarLevels) == 0 {
+			continue
+		}
+		top := v.starLevels(path+".star_levels", variant.StarLevels)
+		if top != nil {
+			v.featured(path+".star_levels", variant.StarLevels, top)
+		}
+		if batch := conf.BatchGuarantee; batch.BatchSize > 0 && !hasLevel(variant.StarLevels, batch.MinLevel) {
+			v.add(path+".star_levels", "没有不低于 batch_guarantee.min_level %d 的星级", batch.MinLevel)
+		}
+	}
+	if total != 100 {
+		v.add("variants", "percent 之和必须为100，当前为 %d", total)
+	}
+}
+
+// steps 校验阶梯，阶梯的保底替代多连保底，阶梯模式不支持分群
+func (v *confValidator) steps(conf dto.LotteryConf) {
+	if len(conf.Steps) == 0 {
+		v.add("steps", "不能为空")
+	}
+	if len(conf.Segments) > 0 {
+		v.add("segments", "step_up 模式不能配置")
+	}
+	if conf.BatchGuarantee.BatchSize != 0 {
+		v.add("batch_guarantee", "step_up 模式不能配置，使用阶梯的 min_level")
+	}
+	priced := len(conf.Pricing) > 0 || conf.Price > 0
+	for i, step := range conf.Steps {
+		path := fmt.Sprintf("steps[%d]", i)
+		if step.DrawNum <= 0 {
+			v.add(path+".draw_num", "必须大于0")
+		} else if conf.Limit.PerRequest > 0 && step.DrawNum > conf.Limit.PerRequest {
+			v.add(path+".draw_num", "不能大于 limit.per_request %d", conf.Limit.PerRequest)
+		}
+		if len(step.Pricing) == 0 && !priced {
+			v.add(path+".pricing", "活动未配置价格时不能为空")
+		}
+		v.pricing(path+".pricing", step.Pricing)
+		levels := conf.StarLevels
+		if len(step.StarLevels) > 0 {
+			levels = step.StarLevels
+			if top := v.starLevels(path+".star_levels", levels); top != nil {
+				v.featured(path+".star_levels", levels, top)
+			}
+		}
+		if step.MinLevel < 0 {
+			v.add(path+".min_level", "不能小于0")
+		} else if step.MinLevel > 0 && !hasLevel(levels, step.MinLevel) {
+			v.add(path+".min_level", "没有不低于 %d 的星级", step.MinLevel)
+		}
+	}
+}
+
+func hasLevel(levels []*dto.StarLevel, minLevel int) bool {
+	for _, level := range levels {
+		if level != nil && level.Level >= minLevel {
+			return true
+		}
+	}
+	return false
+}
+
+// fallback 有限量奖品时必须配置不限量的替代奖品，各奖池中同一限量奖品共用库存，库存配置必须一致
+func (v *confValidator) fallback(conf dto.LotteryConf) {
+	limited := make(map[int64]bool)
+	stock := make(map[int64]int64)
+	stockPool := make(map[int64]int) // 奖品ID->首次出现的奖池
+	prefixes := []string{"star_levels"}
+	pools := [][]*dto.StarLevel{conf.StarLevels}
+	for i, seg := range conf.Segments {
+		prefixes = append(prefixes, fmt.Sprintf("segments[%d].star_levels", i))
+		pools = append(pools, seg.StarLevels)
+	}
+	for i, step := range conf.Steps {
+		prefixes = append(prefixes, fmt.Sprintf("steps[%d].star_levels", i))
+		pools = append(pools, step.StarLevels)
+	}
+	for i, variant := range conf.Variants {
+		prefixes = append(prefixes, fmt.Sprintf("variants[%d].star_levels", i))
+		pools = append(pools, variant.StarLevels)
+	}
+	for k, levels := range pools {
+		prefix := prefixes[k]
+		for i, level := range levels {
+			if level == nil {
+				continue
+			}
+			for j, prize := range level.Prizes {
+				if prize == nil {
+					continue
+				}
+				if pool, ok := stockPool[prize.Id]; !ok {
+					stockPool[prize.Id] = k
+					stock[prize.Id] = prize.Stock
+				} else if pool != k && stock[prize.Id] != prize.Stock {
+					v.add(fmt.Sprintf("%s[%d].prizes[%d].stock", prefix, i, j), "奖品 %d 在各奖池中的库存不一致", prize.Id)
+				}
+				if prize.Stock > 0 {
+					limited[prize.Id] = true
+				}
+			}
+		}
+	}
+	if len(limited) == 0 {
+		return
+	}
+	if conf.Fallback.Id <= 0 {
+		v.add("fallback.id", "有限量奖品时必须配置")
+	} else if limited[conf.Fallback.Id] {
+		v.add("fallback.id", "不能是限量奖品")
+	}
+	if conf.Fallback.Num <= 0 {
+		v.add("fallback.num", "必须大于0")
+	}
+}
+
+// featured UP奖品只能配置在最高星级，且必须是该星级中的奖品
+func (v *confValidator) featured(prefix string, levels []*dto.StarLevel, top *dto.StarLevel) {
+	for i, level := range levels {
+		if level == nil || len(level.Featured) == 0 {
+			continue
+		}
+		path := fmt.Sprintf("%s[%d].featured", prefix, i)
+		if level != top {
+			v.add(path, "只能配置在最高星级")
+			continue
+		}
+		ids := make(map[int64]bool)
+		for _, prize := range level.Prizes {
+			if prize != nil {
+				ids[prize.Id] = true
+			}
+		}
+		for j, id := range level.Featured {
+			if !ids[id] {
+				v.add(fmt.Sprintf("%s[%d]", path, j), "奖品 %d 不在该星级中", id)
+			}
+		}
+	}
+}
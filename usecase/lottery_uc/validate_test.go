@@ -0,0 +1,196 @@
+package lottery_uc
+
+import (
+	"github.com/linchengzhi/lottery/domain/dto"
+	"github.com/linchengzhi/lottery/domain/types"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestValidateLotteryConf(t *testing.T) {
+	conf := dto.LotteryConf{ActivityId: 12345, Price: 100, StarLevels: validStarLevels()}
+	assert.Empty(t, ValidateLotteryConf(conf))
+
+	conf.Price = 0
+	conf.StarLevels = []*dto.StarLevel{
+		{Level: 1, Weight: 0, Prizes: []*dto.Prize{{Id: 1, Num: 1, Weight: 1}, {Id: 2, Num: 0, Weight: -1}}},
+		{Level: 1, Weight: 10, Prizes: []*dto.Prize{{Id: 1, Num: 1, Weight: 1, Stock: 5}}},
+		{Level: 3, Weight: 10, Featured: []int64{9}},
+	}
+	conf.StarLevels[1].Prizes = append(conf.StarLevels[1].Prizes,
+		&dto.Prize{Id: 3, Num: 1, Weight: 1, Unique: true},
+		&dto.Prize{Id: 4, Num: 1, Weight: 1, Unique: true, Duplicate: &dto.DuplicateConf{ItemId: 9, Currency: "gold"}},
+		&dto.Prize{Id: 5, Num: 1, Weight: 1, Duplicate: &dto.DuplicateConf{ItemId: 9, Num: 1}},
+		&dto.Prize{Id: 6, Num: 1, Weight: 1, Content: &dto.PrizeContent{Gold: 500, Items: []dto.Item{{Id: 101, Num: 2}}}},
+		&dto.Prize{Id: 7, Num: 1, Weight: 1, Content: &dto.PrizeContent{Items: []dto.Item{{Id: 0, Num: 1}}, Gold: -1}},
+	)
+	conf.Pity = dto.PityConf{Hard: 10, Soft: 10}
+
+	errs := ValidateLotteryConf(conf)
+	paths := confErrorPaths(errs)
+	assert.ElementsMatch(t, []string{
+		"price",
+		"star_levels[0].weight",
+		"star_levels[0].prizes[1].num",
+		"star_levels[0].prizes[1].weight",
+		"star_levels[1].level",
+		"star_levels[1].prizes[0].id",
+		"star_levels[1].prizes[1].duplicate",
+		"star_levels[1].prizes[2].duplicate",
+		"star_levels[1].prizes[2].duplicate.num",
+		"star_levels[1].prizes[3].duplicate",
+		"star_levels[1].prizes[5].content.items[0].id",
+		"star_levels[1].prizes[5].content.gold",
+		"star_levels[2].prizes",
+		"star_levels[2].featured[0]",
+		"pity.soft",
+		"fallback.id",
+		"fallback.num",
+	}, paths)
+	assert.Contains(t, errs.Error(), "star_levels[1].prizes[0].id: 奖品 1 与 star_levels[0].prizes[0] 重复")
+}
+
+func TestValidateLotteryConf_Pricing(t *testing.T) {
+	conf := dto.LotteryConf{ActivityId: 12345, StarLevels: validStarLevels()}
+	conf.Pricing = []dto.PriceConf{
+		{Currency: "crystal", Price: 160, Bundles: []dto.BundleConf{{DrawNum: 10, Price: 1440}}},
+		{Currency: "stone", Price: 160},
+	}
+	assert.Empty(t, ValidateLotteryConf(conf))
+
+	conf.Pricing = []dto.PriceConf{
+		{Currency: "crystal", Price: 160, Bundles: []dto.BundleConf{{DrawNum: 1, Price: 0}}},
+		{Currency: "crystal", Price: 0},
+		{Currency: "ticket", Price: 1},
+		{Currency: "item", Price: 1},
+		{Currency: "stone", ItemId: 2001, Price: 1},
+	}
+	paths := confErrorPaths(ValidateLotteryConf(conf))
+	assert.ElementsMatch(t, []string{
+		"pricing[0].bundles[0].draw_num",
+		"pricing[0].bundles[0].price",
+		"pricing[1].currency",
+		"pricing[1].price",
+		"pricing[2].currency",
+		"pricing[3].item_id",
+		"pricing[4].item_id",
+	}, paths)
+}
+
+func validStarLevels() []*dto.StarLevel {
+	levels := createStarLevels()
+	for _, level := range levels {
+		for _, prize := range level.Prizes {
+			prize.Num = 1
+		}
+	}
+	return levels
+}
+
+// confErrorPaths 获取配置错误的字段路径
+func confErrorPaths(errs dto.ConfErrors) []string {
+	paths := make([]string, 0, len(errs))
+	for _, e := range errs {
+		paths = append(paths, e.Path)
+	}
+	return paths
+}
+
+func TestValidateLotteryConf_Segments(t *testing.T) {
+	conf := dto.LotteryConf{ActivityId: 12345, Price: 100, StarLevels: validStarLevels()}
+	conf.Fallback = dto.Item{Id: 1, Num: 1}
+	conf.Segments = []dto.SegmentConf{
+		{Name: "new", NewUserDays: 7, StarLevels: []*dto.StarLevel{{Level: 1, Weight: 1, Prizes: []*dto.Prize{{Id: 1, Num: 1, Weight: 1}}}}},
+		{Name: "new", StarLevels: []*dto.StarLevel{{Level: 1, Weight: 1, Prizes: []*dto.Prize{{Id: 2, Num: 1, Weight: 1, Stock: 5}}}}},
+		{Name: "vip", MinVip: 5, MaxVip: 3},
+	}
+	paths := confErrorPaths(ValidateLotteryConf(conf))
+	assert.ElementsMatch(t, []string{
+		"segments[1].name",
+		"segments[1]",
+		"segments[1].star_levels[0].prizes[0].stock",
+		"segments[2].max_vip",
+		"segments[2].star_levels",
+	}, paths)
+}
+
+func TestValidateLotteryConf_Box(t *testing.T) {
+	conf := dto.LotteryConf{ActivityId: 12345, Price: 100, Mode: types.ActivityModeBox}
+	conf.Box = dto.BoxConf{Prizes: []dto.BoxPrize{{Id: 1, Num: 1, Count: 9}, {Id: 2, Num: 1, Count: 1}}, GrandPrize: 2}
+	assert.Empty(t, ValidateLotteryConf(conf))
+
+	conf.StarLevels = validStarLevels()
+	conf.Box = dto.BoxConf{Prizes: []dto.BoxPrize{{Id: 1, Num: 1, Count: 9}, {Id: 1, Num: 0, Count: 0}}, GrandPrize: 3}
+	paths := confErrorPaths(ValidateLotteryConf(conf))
+	assert.ElementsMatch(t, []string{
+		"star_levels",
+		"box.prizes[1].id",
+		"box.prizes[1].num",
+		"box.prizes[1].count",
+		"box.grand_prize",
+	}, paths)
+}
+
+func TestValidateLotteryConf_StepUp(t *testing.T) {
+	conf := dto.LotteryConf{ActivityId: 12345, StarLevels: validStarLevels(), Mode: types.ActivityModeStepUp}
+	conf.Limit.PerRequest = 10
+	conf.Steps = []dto.StepConf{
+		{DrawNum: 10, Pricing: []dto.PriceConf{{Currency: types.CurrencyStone, Price: 80}}},
+		{DrawNum: 10, Pricing: []dto.PriceConf{{Currency: types.CurrencyStone, Price: 160}}, MinLevel: 3},
+	}
+	assert.Empty(t, ValidateLotteryConf(conf))
+
+	conf.BatchGuarantee = dto.BatchGuaranteeConf{BatchSize: 10, MinLevel: 2}
+	conf.Steps = append(conf.Steps, dto.StepConf{DrawNum: 20, MinLevel: 4})
+	paths := confErrorPaths(ValidateLotteryConf(conf))
+	assert.ElementsMatch(t, []string{
+		"batch_guarantee",
+		"steps[2].draw_num",
+		"steps[2].pricing",
+		"steps[2].min_level",
+	}, paths)
+}
+
+func TestValidateLotteryConf_Spark(t *testing.T) {
+	conf := dto.LotteryConf{ActivityId: 12345, Price: 100, StarLevels: validStarLevels()}
+	conf.Spark = dto.SparkConf{PerDraw: 1, Cost: 300, Prizes: []dto.Item{{Id: 301, Num: 1}, {Id: 302, Num: 1}}}
+	assert.Empty(t, ValidateLotteryConf(conf))
+
+	conf.Spark = dto.SparkConf{PerDraw: 1, Prizes: []dto.Item{{Id: 301, Num: 1}, {Id: 301, Num: 0}}}
+	paths := confErrorPaths(ValidateLotteryConf(conf))
+	assert.ElementsMatch(t, []string{"spark.cost", "spark.prizes[1].id", "spark.prizes[1].num"}, paths)
+
+	conf.Spark = dto.SparkConf{Cost: 300}
+	assert.Equal(t, "spark", ValidateLotteryConf(conf)[0].Path)
+}
+
+func TestValidateLotteryConf_Free(t *testing.T) {
+	conf := dto.LotteryConf{ActivityId: 12345, Price: 100, StarLevels: validStarLevels()}
+	conf.Free = dto.FreeConf{Daily: true, Timezone: "Asia/Shanghai"}
+	assert.Empty(t, ValidateLotteryConf(conf))
+
+	conf.Free = dto.FreeConf{Daily: true, Hours: 8, Timezone: "Mars/Base"}
+	paths := confErrorPaths(ValidateLotteryConf(conf))
+	assert.ElementsMatch(t, []string{"free", "free.timezone"}, paths)
+}
+
+func TestValidateLotteryConf_Variants(t *testing.T) {
+	conf := dto.LotteryConf{ActivityId: 12345, Price: 100, StarLevels: validStarLevels()}
+	conf.Variants = []dto.VariantConf{
+		{Id: "a", Percent: 50},
+		{Id: "b", Percent: 50, Pricing: []dto.PriceConf{{Currency: types.CurrencyStone, Price: 80}}},
+	}
+	assert.Empty(t, ValidateLotteryConf(conf))
+
+	conf.Variants = []dto.VariantConf{
+		{Id: "a", Percent: 50},
+		{Id: "a", Percent: 0, Pricing: []dto.PriceConf{{Currency: "diamond", Price: 80}}},
+	}
+	paths := confErrorPaths(ValidateLotteryConf(conf))
+	assert.ElementsMatch(t, []string{
+		"variants[1].id",
+		"variants[1].percent",
+		"variants[1].pricing[0].currency",
+		"variants",
+	}, paths)
+}
@@ -0,0 +1,82 @@
+package lottery_uc
+
+import (
+	"context"
+	"github.com/linchengzhi/lottery/domain/cerror"
+	"github.com/linchengzhi/lottery/domain/dto"
+	"hash/fnv"
+	"strconv"
+)
+
+// poolVariant 实验分组及其奖池
+type poolVariant struct {
+	conf dto.VariantConf
+	pool *PrizePoolUc
+}
+
+// createVariants 创建实验分组奖池，分组替换星级奖品或价格，共用限量奖品库存
+func (p *PrizePoolUc) createVariants(conf dto.LotteryConf) error {
+	for _, v := range conf.Variants {
+		vConf := conf
+		vConf.Variants = nil
+		if len(v.StarLevels) > 0 {
+			vConf.StarLevels = v.StarLevels
+		}
+		if len(v.Pricing) > 0 {
+			vConf.Pricing = v.Pricing
+		}
+		puc, err := NewPrizePoolUc(p.log, vConf)
+		if err != nil {
+			return err
+		}
+		pool := puc.(*PrizePoolUc)
+		pool.variant = v.Id
+		for id, stock := range pool.stock {
+			p.stock[id] = stock
+		}
+		p.variants = append(p.variants, &poolVariant{conf: v, pool: pool})
+	}
+	return nil
+}
+
+// variantBucket 将用户哈希到[0,100)，加入活动ID使同一用户在不同活动中的分组相互独立
+func variantBucket(activityId, userId int64) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(strconv.FormatInt(activityId, 10) + ":" + strconv.FormatInt(userId, 10)))
+	return int64(h.Sum64() % 100)
+}
+
+func (p *PrizePoolUc) getVariants(ctx context.Context) []dto.VariantConf {
+	variants := make([]dto.VariantConf, 0, len(p.variants))
+	for _, v := range p.variants {
+		variants = append(variants, v.conf)
+	}
+	return variants
+}
+
+// matchVariant 按流量百分比的累加区间选择分组，没有分组时返回活动奖池
+func (p *PrizePoolUc) matchVariant(ctx context.Context, userId int64) IPrizePoolUc {
+	if len(p.variants) == 0 {
+		return p
+	}
+	bucket := variantBucket(p.activityId, userId)
+	for _, v := range p.variants {
+		if bucket < v.conf.Percent {
+			return v.pool
+		}
+		bucket -= v.conf.Percent
+	}
+	return p
+}
+
+func (p *PrizePoolUc) getVariantPool(ctx context.Context, id string) (IPrizePoolUc, error) {
+	if id == p.variant {
+		return p, nil
+	}
+	for _, v := range p.variants {
+		if v.conf.Id == id {
+			return v.pool, nil
+		}
+	}
+	return nil, cerror.ErrNoVariant
+}